@@ -3,12 +3,15 @@ package assets
 import (
 	_ "embed"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/models"
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/docker"
 	"go.uber.org/zap"
 )
 
@@ -18,14 +21,30 @@ var LogoContent string
 //go:embed logstash.yml
 var logstashYmlContent string
 
-//go:embed docker-compose.yml
-var dockerComposeContent []byte
-
 var (
 	tempDir string
 	mu      sync.Mutex // Protect tempDir creation
 )
 
+// Named volumes of the services, they survive container removal
+const (
+	syslogDataVolume   = "nfg-syslog-data"   // holds the syslog-ng disk buffers and persist file
+	logstashDataVolume = "nfg-logstash-data" // holds the logstash polling checkpoints and queues
+)
+
+// Special values for the network nfg-logstash is attached to
+const (
+	DefaultLogstashNetwork = "tpotce_nginx_local"
+	LogstashNetworkNone    = "none" // no extra network, only the default bridge
+	LogstashNetworkHost    = "host" // the host network stack
+)
+
+// logOptions of the json-file log driver, shared by all services
+var logOptions = map[string]string{
+	"max-size": "10m",
+	"max-file": "3",
+}
+
 type ConfigType string
 
 const (
@@ -33,13 +52,22 @@ const (
 	LogstashConfig ConfigType = "logstash"
 )
 
-type ComposeOptions struct {
-	ConfigContent  string
-	ConfigType     ConfigType
-	SyslogServices *models.SyslogServices // nil if not applicable
+type ContainerOptions struct {
+	ConfigContent string
+	ConfigType    ConfigType
+	SyslogPorts   []int             // UDP ports published by nfg-syslog, empty if not applicable
+	CAFile        string            // host CA bundle mounted into nfg-syslog, empty if not applicable
+	Env           map[string]string // secrets passed to the container as environment variables
+	ExtraVolumes  []string          // additional read-only mounts "host:container" for nfg-logstash
+	Plugins       []string          // logstash plugins installed when nfg-logstash starts
+	Network       string            // network nfg-logstash is attached to, empty for the default
+	LogstashYml   map[string]string // values of the {{KEY}} placeholders in logstash.yml
 }
 
-func GetDockerComposeFile(opts ComposeOptions) (string, error) {
+// ContainerSpec writes the config files of a service and returns the spec of
+// its container. Secrets are only passed in the environment, they are never
+// written to disk. The image is left to the caller, see ServiceImage.
+func ContainerSpec(opts ContainerOptions) (docker.ContainerSpec, error) {
 	mu.Lock()
 	defer mu.Unlock()
 
@@ -48,82 +76,152 @@ func GetDockerComposeFile(opts ComposeOptions) (string, error) {
 		var err error
 		tempDir, err = os.MkdirTemp("", "nfgtfa-*")
 		if err != nil {
-			return "", fmt.Errorf("failed to create temp directory: %w", err)
+			return docker.ContainerSpec{}, fmt.Errorf("failed to create temp directory: %w", err)
 		}
 		zap.L().Debug("Created temp directory", zap.String("tempDir", tempDir))
 	}
 
-	// Start with the original docker-compose content
-	updatedCompose := string(dockerComposeContent)
-
-	// Handle config based on type
-	if opts.ConfigContent != "" {
-		var configFileName string
-		var originalPath string
-
-		switch opts.ConfigType {
-		case SyslogConfig:
-			configFileName = "syslog-ng.conf"
-			originalPath = "../syslog/syslog-ng.conf"
-			zap.L().Debug("Preparing syslog config",
-				zap.String("configFile", configFileName),
-				zap.String("originalPath", originalPath))
-			if opts.SyslogServices != nil {
-				updatedCompose = strings.ReplaceAll(
-					updatedCompose,
-					`      - "{{SYSLOG_PORTS}}"`,
-					buildSyslogPorts(*opts.SyslogServices),
-				)
-			}
-
-		case LogstashConfig:
-			configFileName = "logstash.conf"
-			originalPath = "../logstash/logstash.conf"
-			zap.L().Debug("Preparing logstash config",
-				zap.String("configFile", configFileName),
-				zap.String("originalPath", originalPath))
-
-			// Write logstash.yml in addition to the config
-			ymlFile := filepath.Join(tempDir, "logstash.yml")
-			if err := os.WriteFile(ymlFile, []byte(logstashYmlContent), 0644); err != nil {
-				return "", fmt.Errorf("failed to create logstash.yml: %w", err)
-			}
-			zap.L().Info("Wrote logstash.yml", zap.String("path", ymlFile))
-
-			updatedCompose = strings.ReplaceAll(updatedCompose, "../logstash/logstash.yml", ymlFile)
-
-		default:
-			return "", fmt.Errorf("unsupported config type: %s", opts.ConfigType)
-		}
+	var spec docker.ContainerSpec
+	var err error
+	switch opts.ConfigType {
+	case SyslogConfig:
+		spec, err = syslogSpec(opts)
+	case LogstashConfig:
+		spec, err = logstashSpec(opts)
+	default:
+		return docker.ContainerSpec{}, fmt.Errorf("unsupported config type: %s", opts.ConfigType)
+	}
+	if err != nil {
+		return docker.ContainerSpec{}, err
+	}
 
-		// If {{SYSLOG_PORTS}} was never replaced (logstash-only mode),
-		// remove the entire nfg-syslog service block from the compose content.
-		if strings.Contains(updatedCompose, "{{SYSLOG_PORTS}}") {
-			updatedCompose = removeSyslogService(updatedCompose)
-		}
+	for _, key := range slices.Sorted(maps.Keys(opts.Env)) {
+		spec.Env = append(spec.Env, key+"="+opts.Env[key])
+	}
+	spec.RestartPolicy = "unless-stopped"
+	spec.LogOptions = logOptions
+	spec.Labels = map[string]string{"com.nxtfireguard.tfa.service": spec.Name}
+	return spec, nil
+}
 
-		// Write config to temp file
-		configFile := filepath.Join(tempDir, configFileName)
-		err := os.WriteFile(configFile, []byte(opts.ConfigContent), 0644)
+func syslogSpec(opts ContainerOptions) (docker.ContainerSpec, error) {
+	configFile, err := writeConfigFile("syslog-ng.conf", opts.ConfigContent)
+	if err != nil {
+		return docker.ContainerSpec{}, fmt.Errorf("failed to create syslog config file: %w", err)
+	}
+
+	spec := docker.ContainerSpec{
+		Name: "nfg-syslog",
+		Env:  []string{"PUID=1000", "PGID=1000", "TZ=Europe/Berlin"},
+		Binds: []string{
+			syslogDataVolume + ":/config",
+			configFile + ":/config/syslog-ng.conf",
+		},
+		Healthcheck: &docker.Healthcheck{
+			Test:        []string{"CMD", "syslog-ng-ctl", "healthcheck", "--control=/config/syslog-ng.ctl"},
+			Interval:    10 * time.Second,
+			Timeout:     5 * time.Second,
+			Retries:     3,
+			StartPeriod: 10 * time.Second,
+		},
+	}
+	for _, port := range opts.SyslogPorts {
+		spec.Ports = append(spec.Ports, docker.Port{Port: port, Protocol: "udp"})
+	}
+	if opts.CAFile != "" {
+		caFile, err := filepath.Abs(opts.CAFile)
 		if err != nil {
-			return "", fmt.Errorf("failed to create %s config file: %w", opts.ConfigType, err)
+			return docker.ContainerSpec{}, fmt.Errorf("failed to resolve CA file path: %w", err)
 		}
-		zap.L().Debug("Wrote config file", zap.String("path", configFile))
+		spec.Binds = append(spec.Binds, caFile+":/etc/ssl/nfg/ca.pem:ro")
+	}
+	return spec, nil
+}
 
-		// Replace the relative path with absolute temp file path
-		updatedCompose = strings.ReplaceAll(updatedCompose, originalPath, configFile)
+func logstashSpec(opts ContainerOptions) (docker.ContainerSpec, error) {
+	configFile, err := writeConfigFile("logstash.conf", opts.ConfigContent)
+	if err != nil {
+		return docker.ContainerSpec{}, fmt.Errorf("failed to create logstash config file: %w", err)
 	}
 
-	// Write the updated docker-compose file
-	dockerComposeFile := filepath.Join(tempDir, "docker-compose.yml")
-	err := os.WriteFile(dockerComposeFile, []byte(updatedCompose), 0644)
+	// Write logstash.yml in addition to the config
+	yml := logstashYmlContent
+	for key, value := range opts.LogstashYml {
+		yml = strings.ReplaceAll(yml, "{{"+key+"}}", value)
+	}
+	if strings.Contains(yml, "{{") {
+		return docker.ContainerSpec{}, fmt.Errorf("logstash.yml has unset placeholders")
+	}
+	ymlFile, err := writeConfigFile("logstash.yml", yml)
 	if err != nil {
-		return "", fmt.Errorf("failed to create docker-compose file: %w", err)
+		return docker.ContainerSpec{}, fmt.Errorf("failed to create logstash.yml: %w", err)
+	}
+
+	spec := docker.ContainerSpec{
+		Name: "nfg-logstash",
+		Binds: []string{
+			configFile + ":/usr/share/logstash/pipeline/logstash.conf",
+			ymlFile + ":/usr/share/logstash/config/logstash.yml",
+			logstashDataVolume + ":/usr/share/logstash/data",
+		},
+		NetworkMode: LogstashNetworkMode(opts.Network),
+		Healthcheck: &docker.Healthcheck{
+			Test:     []string{"CMD", "curl", "-f", "http://localhost:9600/_node/stats"},
+			Interval: 30 * time.Second,
+			Timeout:  10 * time.Second,
+			Retries:  3,
+		},
+	}
+	for _, v := range opts.ExtraVolumes {
+		spec.Binds = append(spec.Binds, v+":ro")
+	}
+	if len(opts.Plugins) > 0 {
+		spec.Entrypoint = pluginEntrypoint(opts.Plugins)
+	}
+	return spec, nil
+}
+
+// LogstashNetworkMode returns the docker network mode for the configured
+// logstash network
+func LogstashNetworkMode(network string) string {
+	switch network {
+	case "":
+		return DefaultLogstashNetwork
+	case LogstashNetworkNone:
+		return "bridge"
+	default:
+		return network
+	}
+}
+
+// UpdateConfigFile rewrites the config file mounted into a running container.
+// The file is rewritten in place so the container's bind mount sees the change.
+func UpdateConfigFile(configType ConfigType, content string) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if tempDir == "" {
+		return "", fmt.Errorf("no %s config file has been written yet", configType)
 	}
-	zap.L().Info("Wrote docker-compose.yml", zap.String("path", dockerComposeFile))
-	zap.L().Debug("docker-compose.yml content", zap.String("content", updatedCompose[:min(len(updatedCompose), 1500)]))
 
-	return dockerComposeFile, nil
+	switch configType {
+	case SyslogConfig:
+		return writeConfigFile("syslog-ng.conf", content)
+	case LogstashConfig:
+		return writeConfigFile("logstash.conf", content)
+	default:
+		return "", fmt.Errorf("unsupported config type: %s", configType)
+	}
+}
+
+// writeConfigFile writes a config file into tempDir, callers must hold mu
+func writeConfigFile(name string, content string) (string, error) {
+	configFile := filepath.Join(tempDir, name)
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		return "", err
+	}
+	zap.L().Debug("Wrote config file", zap.String("path", configFile))
+	return configFile, nil
 }
 
 // Cleanup removes all temporary files
@@ -137,44 +235,13 @@ func Cleanup() {
 	}
 }
 
-func buildSyslogPorts(s models.SyslogServices) string {
-	var ports []string
-	if s.SyslogCiscoFtdEnabled {
-		ports = append(ports, "      - \"514:514/udp\"")
-	}
-	if s.SyslogCiscoIseEnabled {
-		ports = append(ports, "      - \"1025:1025/udp\"")
+// pluginEntrypoint wraps the logstash entrypoint so missing plugins are
+// installed before logstash starts
+func pluginEntrypoint(plugins []string) []string {
+	var script []string
+	for _, p := range plugins {
+		script = append(script, fmt.Sprintf("bin/logstash-plugin list %s >/dev/null 2>&1 || bin/logstash-plugin install %s", p, p))
 	}
-	if s.SyslogOpnsenseEnabled {
-		ports = append(ports, "      - \"1026:1026/udp\"")
-	}
-	if s.SyslogSuricataEnabled {
-		ports = append(ports, "      - \"1027:1027/udp\"")
-	}
-	return strings.Join(ports, "\n")
-}
-
-// removeSyslogService strips the nfg-syslog service block from the compose content
-// when syslog is not configured, preventing docker compose from choking on the
-// unresolved {{SYSLOG_PORTS}} placeholder.
-func removeSyslogService(compose string) string {
-	lines := strings.Split(compose, "\n")
-	var result []string
-	skip := false
-
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "nfg-syslog:" {
-			skip = true
-			continue
-		}
-		// Stop at the next sibling service key (same indentation level: "  somekey:")
-		if skip && len(line) >= 2 && line[0] == ' ' && line[1] == ' ' && line[2] != ' ' && strings.HasSuffix(strings.TrimSpace(line), ":") {
-			skip = false
-		}
-		if !skip {
-			result = append(result, line)
-		}
-	}
-
-	return strings.Join(result, "\n")
+	script = append(script, "exec /usr/local/bin/docker-entrypoint")
+	return []string{"/bin/bash", "-c", strings.Join(script, " && ")}
 }
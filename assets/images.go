@@ -0,0 +1,167 @@
+package assets
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Image is the image a service runs. With a digest it is pulled and run by
+// digest, so a moved tag can't change what runs.
+type Image struct {
+	Repository string
+	Tag        string
+	Digest     string // "sha256:<hex>" of the manifest list, empty to use the tag
+}
+
+// Ref returns the reference the image is pulled and run by
+func (i Image) Ref() string {
+	if i.Digest != "" {
+		return i.Repository + "@" + i.Digest
+	}
+	return i.TagRef()
+}
+
+// TagRef returns the "repository:tag" reference of the image
+func (i Image) TagRef() string {
+	return i.Repository + ":" + i.Tag
+}
+
+func (i Image) String() string {
+	if i.Digest != "" {
+		return i.TagRef() + "@" + i.Digest
+	}
+	return i.TagRef()
+}
+
+// serviceImages are the images of the services.
+//
+// TODO: set Digest to the sha256 digest of the multi-arch manifest list of
+// each tag, from `docker buildx imagetools inspect <repository>:<tag>`. Once
+// set, they are pulled and run by digest, and the unpinned warning of
+// config.PullImages no longer fires. Until then they run by tag, and
+// IMAGE_NFG_SYSLOG and IMAGE_NFG_LOGSTASH can pin them per host.
+var serviceImages = map[string]Image{
+	"nfg-syslog": {
+		Repository: "linuxserver/syslog-ng",
+		Tag:        "4.10.2",
+	},
+	"nfg-logstash": {
+		// tracking_field, last_run_metadata_path and the :last_value and
+		// :present placeholders of the elasticsearch input need version 5.2
+		// of the plugin, which Logstash bundles since 8.19
+		Repository: "docker.elastic.co/logstash/logstash",
+		Tag:        "8.19.2",
+	},
+}
+
+// ImageOptions change where the service images are pulled from
+type ImageOptions struct {
+	Mirror    string            // registry host replacing the registry of every image, e.g. "mirror.example.com:5000"
+	Prefix    string            // path prepended to the full name of every image, e.g. "harbor.example.com/proxy"
+	Overrides map[string]string // image references by service, used as they are
+}
+
+var imageOptions ImageOptions
+
+var (
+	repositoryPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._/:-]*[a-z0-9]$`)
+	tagPattern        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	digestPattern     = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// SetImageOptions validates and applies the image options
+func SetImageOptions(opts ImageOptions) error {
+	if opts.Mirror != "" && opts.Prefix != "" {
+		return fmt.Errorf("a registry mirror and a registry prefix can't be combined")
+	}
+	if opts.Mirror != "" && (strings.Contains(opts.Mirror, "/") || !repositoryPattern.MatchString(opts.Mirror)) {
+		return fmt.Errorf("invalid registry mirror %q, expected a registry host", opts.Mirror)
+	}
+	opts.Prefix = strings.TrimSuffix(opts.Prefix, "/")
+	if opts.Prefix != "" && !repositoryPattern.MatchString(opts.Prefix) {
+		return fmt.Errorf("invalid registry prefix %q", opts.Prefix)
+	}
+	for service, ref := range opts.Overrides {
+		if _, ok := serviceImages[service]; !ok {
+			return fmt.Errorf("no image found for service %s", service)
+		}
+		if _, err := ParseImage(ref); err != nil {
+			return fmt.Errorf("invalid image for service %s: %w", service, err)
+		}
+	}
+	imageOptions = opts
+	return nil
+}
+
+// ParseImage parses a "repository:tag" reference, optionally followed by
+// "@sha256:<hex>"
+func ParseImage(ref string) (Image, error) {
+	name, digest, _ := strings.Cut(ref, "@")
+	if digest != "" && !digestPattern.MatchString(digest) {
+		return Image{}, fmt.Errorf("invalid digest in %q", ref)
+	}
+
+	// the tag follows the last colon after the last slash, an earlier
+	// colon belongs to the registry port
+	i := strings.LastIndex(name, ":")
+	if i < strings.LastIndex(name, "/") {
+		i = -1
+	}
+	if i < 0 {
+		return Image{}, fmt.Errorf("image %q has no tag", ref)
+	}
+	image := Image{Repository: name[:i], Tag: name[i+1:], Digest: digest}
+	if !repositoryPattern.MatchString(image.Repository) || !tagPattern.MatchString(image.Tag) {
+		return Image{}, fmt.Errorf("invalid image %q", ref)
+	}
+	return image, nil
+}
+
+// Registry returns the host of the registry the image is pulled from
+func (i Image) Registry() string {
+	registry, _ := splitRegistry(i.Repository)
+	return registry
+}
+
+// splitRegistry splits a repository into its registry host and path. Like
+// Docker, names without a registry host are on Docker Hub.
+func splitRegistry(repository string) (string, string) {
+	first, rest, found := strings.Cut(repository, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first, rest
+	}
+	if !found {
+		return "docker.io", "library/" + repository
+	}
+	return "docker.io", repository
+}
+
+// ServiceImage returns the image a service runs, after the image options
+// are applied. An override replaces the image, otherwise the mirror or
+// prefix changes where it is pulled from.
+func ServiceImage(service string) (Image, error) {
+	image, ok := serviceImages[service]
+	if !ok {
+		return Image{}, fmt.Errorf("no image found for service %s", service)
+	}
+	if ref, ok := imageOptions.Overrides[service]; ok {
+		return ParseImage(ref)
+	}
+
+	registry, path := splitRegistry(image.Repository)
+	switch {
+	case imageOptions.Mirror != "":
+		image.Repository = imageOptions.Mirror + "/" + path
+	case imageOptions.Prefix != "":
+		image.Repository = imageOptions.Prefix + "/" + registry + "/" + path
+	}
+	return image, nil
+}
+
+// Services returns the names of all services, sorted
+func Services() []string {
+	return slices.Sorted(maps.Keys(serviceImages))
+}
@@ -0,0 +1,148 @@
+package assets
+
+import "testing"
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseImage(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    Image
+		wantErr bool
+	}{
+		{ref: "linuxserver/syslog-ng:4.10.2", want: Image{Repository: "linuxserver/syslog-ng", Tag: "4.10.2"}},
+		{ref: "logstash:8.19.2@" + testDigest, want: Image{Repository: "logstash", Tag: "8.19.2", Digest: testDigest}},
+		{ref: "mirror.example.com:5000/logstash/logstash:8.19.2", want: Image{Repository: "mirror.example.com:5000/logstash/logstash", Tag: "8.19.2"}},
+		{ref: "mirror.example.com:5000/logstash/logstash", wantErr: true},
+		{ref: "linuxserver/syslog-ng", wantErr: true},
+		{ref: "linuxserver/syslog-ng@" + testDigest, wantErr: true},
+		{ref: "linuxserver/syslog-ng:4.10.2@sha256:abc", wantErr: true},
+		{ref: "linuxserver/syslog-ng:4.10.2@md5:0123", wantErr: true},
+		{ref: "LinuxServer/syslog-ng:4.10.2", wantErr: true},
+		{ref: "linuxserver/syslog-ng:-latest", wantErr: true},
+		{ref: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := ParseImage(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageRefs(t *testing.T) {
+	pinned := Image{Repository: "linuxserver/syslog-ng", Tag: "4.10.2", Digest: testDigest}
+	if got := pinned.Ref(); got != "linuxserver/syslog-ng@"+testDigest {
+		t.Errorf("got ref %s", got)
+	}
+	if got := pinned.TagRef(); got != "linuxserver/syslog-ng:4.10.2" {
+		t.Errorf("got tag ref %s", got)
+	}
+	if got := pinned.String(); got != "linuxserver/syslog-ng:4.10.2@"+testDigest {
+		t.Errorf("got %s", got)
+	}
+
+	unpinned := Image{Repository: "linuxserver/syslog-ng", Tag: "4.10.2"}
+	if unpinned.Ref() != unpinned.TagRef() || unpinned.String() != unpinned.TagRef() {
+		t.Errorf("unpinned image is not run by tag: %s", unpinned.Ref())
+	}
+}
+
+func TestServiceImagesAreValid(t *testing.T) {
+	for _, service := range Services() {
+		image := serviceImages[service]
+		parsed, err := ParseImage(image.String())
+		if err != nil {
+			t.Errorf("%s: %v", service, err)
+		} else if parsed != image {
+			t.Errorf("%s: got %+v after parsing, want %+v", service, parsed, image)
+		}
+	}
+}
+
+func TestServiceImage(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    ImageOptions
+		service string
+		want    string
+	}{
+		{"default", ImageOptions{}, "nfg-syslog", "linuxserver/syslog-ng:4.10.2"},
+		{"mirror for docker hub", ImageOptions{Mirror: "mirror.example.com:5000"}, "nfg-syslog", "mirror.example.com:5000/linuxserver/syslog-ng:4.10.2"},
+		{"mirror for elastic", ImageOptions{Mirror: "mirror.example.com"}, "nfg-logstash", "mirror.example.com/logstash/logstash:8.19.2"},
+		{"prefix for docker hub", ImageOptions{Prefix: "harbor.example.com/proxy/"}, "nfg-syslog", "harbor.example.com/proxy/docker.io/linuxserver/syslog-ng:4.10.2"},
+		{"prefix for elastic", ImageOptions{Prefix: "harbor.example.com/proxy"}, "nfg-logstash", "harbor.example.com/proxy/docker.elastic.co/logstash/logstash:8.19.2"},
+		{
+			"override is not rewritten",
+			ImageOptions{Mirror: "mirror.example.com", Overrides: map[string]string{"nfg-syslog": "registry.example.com/syslog-ng:4.10.2@" + testDigest}},
+			"nfg-syslog",
+			"registry.example.com/syslog-ng:4.10.2@" + testDigest,
+		},
+		{
+			"override of another service",
+			ImageOptions{Mirror: "mirror.example.com", Overrides: map[string]string{"nfg-syslog": "registry.example.com/syslog-ng:4.10.2"}},
+			"nfg-logstash",
+			"mirror.example.com/logstash/logstash:8.19.2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetImageOptions(tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { SetImageOptions(ImageOptions{}) })
+
+			image, err := ServiceImage(tt.service)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image.String() != tt.want {
+				t.Errorf("got %s, want %s", image, tt.want)
+			}
+		})
+	}
+
+	if _, err := ServiceImage("nfg-unknown"); err == nil {
+		t.Error("expected an unknown service to be rejected")
+	}
+}
+
+func TestImageRegistry(t *testing.T) {
+	tests := map[string]string{
+		"logstash":                             "docker.io",
+		"linuxserver/syslog-ng":                "docker.io",
+		"docker.elastic.co/logstash/logstash":  "docker.elastic.co",
+		"mirror.example.com:5000/syslog-ng":    "mirror.example.com:5000",
+		"localhost/syslog-ng":                  "localhost",
+		"harbor.example.com/proxy/docker.io/x": "harbor.example.com",
+	}
+	for repository, want := range tests {
+		if got := (Image{Repository: repository}).Registry(); got != want {
+			t.Errorf("%s: got %s, want %s", repository, got, want)
+		}
+	}
+}
+
+func TestSetImageOptionsInvalid(t *testing.T) {
+	tests := map[string]ImageOptions{
+		"mirror and prefix":     {Mirror: "mirror.example.com", Prefix: "harbor.example.com/proxy"},
+		"mirror with path":      {Mirror: "mirror.example.com/docker"},
+		"mirror with scheme":    {Mirror: "https://mirror.example.com"},
+		"prefix with space":     {Prefix: "harbor.example.com/my proxy"},
+		"override without tag":  {Overrides: map[string]string{"nfg-syslog": "linuxserver/syslog-ng"}},
+		"override of a service": {Overrides: map[string]string{"nfg-unknown": "linuxserver/syslog-ng:4.10.2"}},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := SetImageOptions(opts); err == nil {
+				SetImageOptions(ImageOptions{})
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/config"
+	"github.com/joho/godotenv"
+)
+
+const imagesUsage = "usage: nfgtfa images export|import <tarball>"
+
+// runImagesCommand moves the service images to hosts without registry
+// access: export writes them to a tarball, import loads one
+func runImagesCommand(args []string) int {
+	if len(args) != 2 || (args[0] != "export" && args[0] != "import") {
+		fmt.Println(imagesUsage)
+		return 2
+	}
+	path := args[1]
+
+	godotenv.Load()
+	config.Load()
+
+	switch args[0] {
+	case "export":
+		fmt.Println("Exporting images to", path, "(pulling them first if needed)")
+		if err := config.ExportImages(path); err != nil {
+			fmt.Println("ERROR:", err)
+			return 1
+		}
+		fmt.Println("Exported images to", path)
+	case "import":
+		fmt.Println("Importing images from", path)
+		missing, err := config.ImportImages(path)
+		if err != nil {
+			fmt.Println("ERROR:", err)
+			return 1
+		}
+		for _, image := range missing {
+			fmt.Println("WARNING: image not in the tarball:", image)
+		}
+		fmt.Println("Imported images from", path)
+	}
+	return 0
+}
@@ -19,6 +19,10 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "images" {
+		os.Exit(runImagesCommand(os.Args[2:]))
+	}
+
 	fmt.Print(assets.LogoContent)
 
 	if _, err := os.Stat(".env"); os.IsNotExist(err) {
@@ -35,6 +39,7 @@ func main() {
 		<-stopChan
 		zap.L().Info("Received termination signal, stopping containers...")
 		config.StopAllContainers()
+		config.StopNativePoller()
 		config.PruneNetworks()
 		os.Exit(0)
 	}()
@@ -48,6 +53,9 @@ func main() {
 
 	zap.L().Info("Threat Feed Aggregator starting up...")
 
+	// Pull the service images in the background, services wait for them when they start
+	go config.PullImages(cfg)
+
 	// Sync config
 	if err := config.Sync(cfg); err != nil {
 		zap.L().Fatal("Initial config sync failed", zap.Error(err))
@@ -3,33 +3,64 @@ package config
 import (
 	"encoding/json"
 	"os"
+	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/assets"
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/docker"
 	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/models"
 )
 
 type Config struct {
-	Debug                 bool
-	AggregatorName        string
-	SyslogEnabled         bool
-	SyslogServices        models.SyslogServices
-	LogstashEnabled       bool
-	AuthSecret            string
-	HeartbeatIdentifier   string
-	HeartbeatUrl          string
-	NfgTfaControllerUrl   string
-	NfgTfaControllerHost  string
-	NfgThreatCollectorUrl string
-	InsecureSkipVerifyTLS bool
-	LogToLoki             bool
-	LokiAddress           string
-	WsKeepalivePeriod     time.Duration
-	ElasticsearchTargets  []ElasticsearchTarget
+	Debug                      bool
+	AggregatorName             string
+	SyslogEnabled              bool
+	SyslogServices             models.SyslogServices
+	SyslogPorts                models.SyslogPorts
+	SyslogPortOverrides        models.SyslogPorts
+	SyslogAllowlists           models.SyslogAllowlists
+	SyslogAllowlistOverrides   models.SyslogAllowlists
+	SyslogFilters              models.SyslogFilters
+	SyslogBodyFormats          models.SyslogBodyFormats
+	SyslogInstances            []models.SyslogInstance
+	SyslogDelivery             models.SyslogDelivery
+	SyslogDiskBufferEnabled    bool
+	SyslogDiskBufferReliable   bool
+	SyslogDiskBufferSizeMB     int
+	SyslogHealthTimeout        time.Duration
+	LogstashEnabled            bool
+	AuthSecret                 string
+	HeartbeatIdentifier        string
+	HeartbeatUrl               string
+	NfgTfaControllerUrl        string
+	NfgTfaControllerHost       string
+	NfgThreatCollectorUrl      string
+	InsecureSkipVerifyTLS      bool
+	TLSCAFile                  string
+	LogToLoki                  bool
+	LokiAddress                string
+	WsKeepalivePeriod          time.Duration
+	ElasticsearchTargets       []ElasticsearchTarget
+	RemoteElasticsearchTargets []ElasticsearchTarget // decrypted targets from the controller
+	LogstashIndexPattern       string
+	LogstashSchedule           string
+	LogstashPageSize           int
+	LogstashTrackingField      string
+	LogstashNetwork            string
+	LogstashSettings           models.LogstashSettings
+	LogstashSettingOverrides   models.LogstashSettings
+	LogstashFilter             models.LogstashFilter
+	LogstashMode               string
+	LogstashPollInterval       time.Duration
+	LogstashStateDir           string
 
 	// in memory contianer configs
-	SyslogConfig   string
-	LogstashConfig string
+	SyslogConfig         string
+	SyslogPublishedPorts []int // ports of the deployed SyslogConfig
+	LogstashConfig       string
 }
 
 func (c *Config) SetSyslogEnabled(v bool) {
@@ -60,18 +91,57 @@ func AnyEnabled(s models.SyslogServices) bool {
 		s.SyslogSuricataEnabled
 }
 
+// AnySyslogSource reports whether at least one syslog service or named
+// instance is configured
+func (c *Config) AnySyslogSource() bool {
+	return AnyEnabled(c.SyslogServices) || len(c.SyslogInstances) > 0
+}
+
 // ApplyRemoteConfig applies all remote config changes atomically,
 // then fires handlers only for what actually changed.
 func (c *Config) ApplyRemoteConfig(r RemoteConfig) {
 	syslogDirty := c.SyslogEnabled != r.SyslogEnabled ||
-		c.SyslogServices != r.SyslogServices
+		c.SyslogServices != r.SyslogServices ||
+		c.SyslogPorts != r.SyslogPorts ||
+		!reflect.DeepEqual(c.SyslogAllowlists, r.SyslogAllowlists) ||
+		!reflect.DeepEqual(c.SyslogFilters, r.SyslogFilters) ||
+		c.SyslogBodyFormats != r.SyslogBodyFormats ||
+		!reflect.DeepEqual(c.SyslogInstances, r.SyslogInstances) ||
+		c.SyslogDelivery != r.SyslogDelivery
 
-	logstashDirty := c.LogstashEnabled != r.LogstashEnabled
+	logstashDirty := c.LogstashEnabled != r.LogstashEnabled ||
+		(r.LogstashEnabled && c.LogstashSettings != r.LogstashSettings)
+	// filter changes only touch the pipeline, which logstash reloads by itself
+	logstashReload := !logstashDirty && r.LogstashEnabled &&
+		!reflect.DeepEqual(c.LogstashFilter, r.LogstashFilter)
+
+	remoteTargets := decryptRemoteTargets(c, r.ElasticsearchTargets)
+	targetsChanged := !reflect.DeepEqual(c.RemoteElasticsearchTargets, remoteTargets)
+	prevSpec := currentLogstashContainerSpec(c)
 
 	// Apply all mutations synchronously before any goroutine is spawned
 	c.SyslogEnabled = r.SyslogEnabled
 	c.SyslogServices = r.SyslogServices
+	c.SyslogPorts = r.SyslogPorts
+	c.SyslogAllowlists = r.SyslogAllowlists
+	c.SyslogFilters = r.SyslogFilters
+	c.SyslogBodyFormats = r.SyslogBodyFormats
+	c.SyslogInstances = r.SyslogInstances
+	c.SyslogDelivery = r.SyslogDelivery
 	c.LogstashEnabled = r.LogstashEnabled
+	c.LogstashSettings = r.LogstashSettings
+	c.LogstashFilter = r.LogstashFilter
+	c.RemoteElasticsearchTargets = remoteTargets
+
+	// new targets only need a pipeline reload, unless the container itself
+	// changes: new secrets, CA mounts or plugins
+	if targetsChanged && r.LogstashEnabled && !logstashDirty {
+		if prevSpec.equal(currentLogstashContainerSpec(c)) {
+			logstashReload = true
+		} else {
+			logstashDirty = true
+		}
+	}
 
 	if syslogDirty {
 		go HandleSyslogChange(c)
@@ -79,26 +149,72 @@ func (c *Config) ApplyRemoteConfig(r RemoteConfig) {
 	if logstashDirty {
 		go HandleLogstashChange(c)
 	}
+	if logstashReload {
+		go ReloadLogstashPipeline(c)
+	}
 }
 
 func Load() *Config {
 	debug, _ := strconv.ParseBool(getEnv("DEBUG", "false"))
 	insecureSkipVerify, _ := strconv.ParseBool(getEnv("SKIP_VERIFY_TLS", "false"))
 	logToLoki, _ := strconv.ParseBool(getEnv("LOG_TO_LOKI", "true"))
+	diskBufferEnabled, _ := strconv.ParseBool(getEnv("SYSLOG_DISK_BUFFER_ENABLED", "true"))
+	diskBufferReliable, _ := strconv.ParseBool(getEnv("SYSLOG_DISK_BUFFER_RELIABLE", "false"))
+	diskBufferSizeMB, err := strconv.Atoi(getEnv("SYSLOG_DISK_BUFFER_SIZE_MB", "1024"))
+	if err != nil || diskBufferSizeMB < 1 {
+		panic("invalid SYSLOG_DISK_BUFFER_SIZE_MB: " + getEnv("SYSLOG_DISK_BUFFER_SIZE_MB", ""))
+	}
+	logstashPageSize, err := strconv.Atoi(getEnv("LOGSTASH_ES_PAGE_SIZE", "1000"))
+	if err != nil || logstashPageSize < 1 {
+		panic("invalid LOGSTASH_ES_PAGE_SIZE: " + getEnv("LOGSTASH_ES_PAGE_SIZE", ""))
+	}
+	logstashPollInterval, err := strconv.Atoi(getEnv("LOGSTASH_NATIVE_INTERVAL_SECONDS", "2"))
+	if err != nil || logstashPollInterval < 1 {
+		panic("invalid LOGSTASH_NATIVE_INTERVAL_SECONDS: " + getEnv("LOGSTASH_NATIVE_INTERVAL_SECONDS", ""))
+	}
+	syslogHealthTimeout, err := strconv.Atoi(getEnv("SYSLOG_HEALTH_TIMEOUT_SECONDS", "60"))
+	if err != nil || syslogHealthTimeout < 1 {
+		panic("invalid SYSLOG_HEALTH_TIMEOUT_SECONDS: " + getEnv("SYSLOG_HEALTH_TIMEOUT_SECONDS", ""))
+	}
+	if containerRuntime, err = docker.RuntimeFromEnv(getEnv("CONTAINER_RUNTIME", docker.RuntimeDocker)); err != nil {
+		panic("invalid container runtime: " + err.Error())
+	}
+	if err := loadImageSettings(); err != nil {
+		panic("invalid image settings: " + err.Error())
+	}
 
 	cfg := &Config{
-		Debug:                 debug,
-		AggregatorName:        getEnv("AGGREGATOR_NAME", ""),
-		AuthSecret:            getEnv("AUTH_SECRET", ""),
-		HeartbeatIdentifier:   getEnv("HEARTBEAT_IDENTIFIER", ""),
-		HeartbeatUrl:          getEnv("HEARTBEAT_URL", "https://heartbeat.nxtfireguard.de"),
-		NfgTfaControllerUrl:   getEnv("NFG_TFA_CONTROLLER_URL", "https://controller.collector.nxtfireguard.de"),
-		NfgTfaControllerHost:  getEnv("NFG_TFA_CONTROLLER_HOST", "controller.collector.nxtfireguard.de"),
-		NfgThreatCollectorUrl: getEnv("THREAT_LOG_COLLECTOR_URL", "https://threat.collector.nxtfireguard.de"),
-		InsecureSkipVerifyTLS: insecureSkipVerify,
-		LogToLoki:             logToLoki,
-		LokiAddress:           getEnv("LOKI_ADDRESS", "https://loki.nxtfireguard.de"),
-		WsKeepalivePeriod:     30 * time.Second,
+		Debug:                    debug,
+		AggregatorName:           getEnv("AGGREGATOR_NAME", ""),
+		AuthSecret:               getEnv("AUTH_SECRET", ""),
+		HeartbeatIdentifier:      getEnv("HEARTBEAT_IDENTIFIER", ""),
+		HeartbeatUrl:             getEnv("HEARTBEAT_URL", "https://heartbeat.nxtfireguard.de"),
+		NfgTfaControllerUrl:      getEnv("NFG_TFA_CONTROLLER_URL", "https://controller.collector.nxtfireguard.de"),
+		NfgTfaControllerHost:     getEnv("NFG_TFA_CONTROLLER_HOST", "controller.collector.nxtfireguard.de"),
+		NfgThreatCollectorUrl:    getEnv("THREAT_LOG_COLLECTOR_URL", "https://threat.collector.nxtfireguard.de"),
+		InsecureSkipVerifyTLS:    insecureSkipVerify,
+		TLSCAFile:                getEnv("TLS_CA_FILE", ""),
+		LogToLoki:                logToLoki,
+		SyslogDiskBufferEnabled:  diskBufferEnabled,
+		SyslogDiskBufferReliable: diskBufferReliable,
+		SyslogDiskBufferSizeMB:   diskBufferSizeMB,
+		SyslogHealthTimeout:      time.Duration(syslogHealthTimeout) * time.Second,
+		LokiAddress:              getEnv("LOKI_ADDRESS", "https://loki.nxtfireguard.de"),
+		WsKeepalivePeriod:        30 * time.Second,
+		LogstashIndexPattern:     getEnv("LOGSTASH_ES_INDEX", "logstash-*"),
+		LogstashSchedule:         getEnv("LOGSTASH_ES_SCHEDULE", "*/2 * * * * *"),
+		LogstashPageSize:         logstashPageSize,
+		LogstashTrackingField:    getEnv("LOGSTASH_ES_TRACKING_FIELD", "@timestamp"),
+		LogstashNetwork:          getEnv("LOGSTASH_NETWORK", assets.DefaultLogstashNetwork),
+		LogstashMode:             getEnv("LOGSTASH_MODE", logstashModeContainer),
+		LogstashPollInterval:     time.Duration(logstashPollInterval) * time.Second,
+		LogstashStateDir:         getEnv("LOGSTASH_NATIVE_STATE_DIR", "./state"),
+	}
+	if cfg.LogstashMode != logstashModeContainer && cfg.LogstashMode != logstashModeNative {
+		panic("invalid LOGSTASH_MODE: " + cfg.LogstashMode)
+	}
+	if !dockerNamePattern.MatchString(cfg.LogstashNetwork) {
+		panic("invalid LOGSTASH_NETWORK: " + cfg.LogstashNetwork)
 	}
 
 	// Parse Elasticsearch targets
@@ -107,9 +223,61 @@ func Load() *Config {
 		panic("failed to parse ELASTICSEARCH_TARGETS: " + err.Error())
 	}
 
+	// Local port overrides take precedence over the ports sent by the controller
+	for _, svc := range syslogServiceDefs {
+		key := "SYSLOG_PORT_" + strings.ToUpper(svc.id)
+		value := getEnv(key, "")
+		if value == "" {
+			continue
+		}
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			panic("invalid " + key + ": " + value)
+		}
+		svc.setPort(&cfg.SyslogPortOverrides, port)
+	}
+
+	// Local Logstash settings take precedence over the ones sent by the controller
+	cfg.LogstashSettingOverrides = models.LogstashSettings{
+		PipelineWorkers:      optionalEnvInt("LOGSTASH_PIPELINE_WORKERS"),
+		BatchSize:            optionalEnvInt("LOGSTASH_PIPELINE_BATCH_SIZE"),
+		QueueMaxMB:           optionalEnvInt("LOGSTASH_QUEUE_MAX_MB"),
+		DLQMaxMB:             optionalEnvInt("LOGSTASH_DLQ_MAX_MB"),
+		OutputRetries:        optionalEnvInt("LOGSTASH_OUTPUT_RETRIES"),
+		OutputPoolMax:        optionalEnvInt("LOGSTASH_OUTPUT_POOL_MAX"),
+		OutputTimeoutSeconds: optionalEnvInt("LOGSTASH_OUTPUT_TIMEOUT_SECONDS"),
+	}
+
+	// Local sender allowlists replace the ones sent by the controller
+	if path := getEnv("SYSLOG_ALLOWLIST_FILE", ""); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			panic("failed to read SYSLOG_ALLOWLIST_FILE: " + err.Error())
+		}
+		if err := json.Unmarshal(data, &cfg.SyslogAllowlistOverrides); err != nil {
+			panic("failed to parse SYSLOG_ALLOWLIST_FILE: " + err.Error())
+		}
+	}
+
 	return cfg
 }
 
+// optionalEnvInt returns the positive integer in key, or 0 if it is unset
+func optionalEnvInt(key string) int {
+	value := getEnv(key, "")
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		panic("invalid " + key + ": " + value)
+	}
+	return n
+}
+
+// dockerNamePattern matches valid docker network names
+var dockerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
 func getEnv(key, fallback string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -1,29 +1,51 @@
 package config
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/assets"
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/docker"
 	"go.uber.org/zap"
 )
 
+// containerRuntime runs the containers, set up by Load
+var containerRuntime docker.Runtime
+
+// SetContainerRuntime replaces the runtime the containers are managed with,
+// e.g. with a docker.Fake in tests
+func SetContainerRuntime(rt docker.Runtime) {
+	containerRuntime = rt
+}
+
+const (
+	dockerTimeout     = 30 * time.Second // plain API calls
+	dockerPullTimeout = 10 * time.Minute // image pulls
+	dockerRunTimeout  = 2 * time.Minute  // one-off containers, on top of pulling their image
+	dockerStopTimeout = 10 * time.Second // grace period of containers that have no state to flush
+)
+
+// Removes networks left behind by docker compose, which older versions used
+// to run the containers
 func PruneNetworks() {
-	output, err := exec.Command("docker", "network", "ls", "--format", "{{.Name}}").CombinedOutput()
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+
+	networks, err := containerRuntime.NetworkList(ctx)
 	if err != nil {
-		zap.L().Warn("Failed to list Docker networks", zap.Error(err), zap.String("output", string(output)))
-	} else {
-		networks := strings.Split(strings.TrimSpace(string(output)), "\n")
-		for _, net := range networks {
-			if strings.HasPrefix(net, "nfgtfa-") && strings.HasSuffix(net, "_default") {
-				zap.L().Info("Removing temporary network", zap.String("network", net))
-				out, err := exec.Command("docker", "network", "rm", net).CombinedOutput()
-				if err != nil {
-					zap.L().Warn("Failed to remove network", zap.String("network", net), zap.Error(err), zap.String("output", string(out)))
-				} else {
-					zap.L().Info("Removed network successfully", zap.String("network", net))
-				}
+		zap.L().Warn("Failed to list Docker networks", zap.Error(err))
+		return
+	}
+	for _, net := range networks {
+		if strings.HasPrefix(net.Name, "nfgtfa-") && strings.HasSuffix(net.Name, "_default") {
+			zap.L().Info("Removing temporary network", zap.String("network", net.Name))
+			if err := containerRuntime.NetworkRemove(ctx, net.ID); err != nil {
+				zap.L().Warn("Failed to remove network", zap.String("network", net.Name), zap.Error(err))
+			} else {
+				zap.L().Info("Removed network successfully", zap.String("network", net.Name))
 			}
 		}
 	}
@@ -31,19 +53,17 @@ func PruneNetworks() {
 
 // Stops all nfg containers
 func StopAllContainers() {
-	err := stopContainer("nfg-syslog")
-	if err != nil {
-		zap.L().Error("failed to stop container nfg-syslog:")
-	}
-	err = stopContainer("nfg-logstash")
-	if err != nil {
-		zap.L().Error("failed to stop container nfg-logstash:")
+	for _, name := range []string{"nfg-syslog", "nfg-logstash"} {
+		if err := stopContainer(name); err != nil {
+			zap.L().Error("Failed to stop container", zap.String("name", name), zap.Error(err))
+		}
 	}
 }
 
-// Starts a container with the given name using docker compose
+// Starts a container with the given name, replacing a leftover container of
+// the same name
 func startContainer(name string, c *Config) error {
-	var opts assets.ComposeOptions
+	var opts assets.ContainerOptions
 
 	// Get the appropriate config by container name
 	switch name {
@@ -51,96 +71,215 @@ func startContainer(name string, c *Config) error {
 		if c.SyslogConfig == "" {
 			return fmt.Errorf("syslog config is empty, cannot start container")
 		}
-		opts = assets.ComposeOptions{
-			ConfigContent:  c.SyslogConfig,
-			ConfigType:     assets.SyslogConfig,
-			SyslogServices: &c.SyslogServices,
+		opts = assets.ContainerOptions{
+			ConfigContent: c.SyslogConfig,
+			ConfigType:    assets.SyslogConfig,
+			SyslogPorts:   c.SyslogPublishedPorts,
+			CAFile:        c.TLSCAFile,
+			Env:           map[string]string{authKeyEnv: c.AuthSecret},
 		}
 	case "nfg-logstash":
 		if c.LogstashConfig == "" {
 			return fmt.Errorf("logstash config is empty, cannot start container")
 		}
-		opts = assets.ComposeOptions{
+		opts = assets.ContainerOptions{
 			ConfigContent: c.LogstashConfig,
 			ConfigType:    assets.LogstashConfig,
+			Env:           logstashSecrets(c),
+			Network:       c.LogstashNetwork,
+			LogstashYml:   logstashYmlValues(c.effectiveLogstashSettings()),
+		}
+		for hostPath, containerPath := range logstashCAFiles(c) {
+			abs, err := filepath.Abs(hostPath)
+			if err != nil {
+				return fmt.Errorf("failed to resolve CA file path: %w", err)
+			}
+			opts.ExtraVolumes = append(opts.ExtraVolumes, abs+":"+containerPath)
+		}
+		if usesOpenSearch(c) {
+			opts.Plugins = append(opts.Plugins, "logstash-input-opensearch")
 		}
 	default:
 		return fmt.Errorf("unknown container name: %s", name)
 	}
 
-	composeFile, err := assets.GetDockerComposeFile(opts)
+	spec, err := assets.ContainerSpec(opts)
 	if err != nil {
-		return fmt.Errorf("failed to get docker-compose file: %w", err)
+		return fmt.Errorf("failed to prepare container %s: %w", name, err)
+	}
+
+	if spec.Image, err = provideImage(name); err != nil {
+		return err
 	}
 
-	zap.L().Info("Starting container", zap.String("name", name), zap.String("composeFile", composeFile))
-	cmd := exec.Command("docker", "compose", "-f", composeFile, "up", "-d", name)
-	output, err := cmd.CombinedOutput()
-	if len(output) > 0 {
-		zap.L().Info("docker compose output", zap.String("output", string(output)))
+	zap.L().Info("Starting container", zap.String("name", name), zap.String("image", spec.Image))
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+
+	if err := containerRuntime.ContainerRemove(ctx, name, true); err != nil && !docker.IsNotFound(err) {
+		return fmt.Errorf("failed to remove leftover container %s: %w", name, err)
 	}
+	id, err := containerRuntime.ContainerCreate(ctx, spec)
 	if err != nil {
-		return fmt.Errorf("failed to start container %s: %w", name, err)
+		return err
+	}
+	if err := containerRuntime.ContainerStart(ctx, id); err != nil {
+		return err
 	}
 
 	zap.L().Info("Container started successfully", zap.String("name", name))
 	return nil
 }
 
-// Force removes a container independently of a docker compose file
+// Force removes a container without waiting for it to stop
 func forceRemoveContainer(name string) error {
 	zap.L().Info("Force removing container", zap.String("name", name))
 
-	cmd := exec.Command("docker", "container", "rm", "-f", name)
-	output, err := cmd.CombinedOutput()
-	if len(output) > 0 {
-		zap.L().Info("docker container rm output", zap.String("output", string(output)))
-	}
-	if err != nil {
-		return fmt.Errorf("failed to force remove container %s: %w", name, err)
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+
+	if err := containerRuntime.ContainerRemove(ctx, name, true); err != nil {
+		return err
 	}
 
 	zap.L().Info("Container force removed successfully", zap.String("name", name))
 	return nil
 }
 
-// Stops a container with the given name using docker compose
-func stopContainer(name string) error {
-	composeFile, err := assets.GetDockerComposeFile(assets.ComposeOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to get docker-compose file: %w", err)
+// Stops a container with a grace period, then removes it. Used for
+// containers that need time to flush their state on shutdown.
+func gracefulRemoveContainer(name string, timeout time.Duration) error {
+	zap.L().Info("Stopping container", zap.String("name", name), zap.Duration("timeout", timeout))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+dockerTimeout)
+	defer cancel()
+
+	if err := containerRuntime.ContainerStop(ctx, name, timeout); err != nil {
+		zap.L().Warn("Failed to stop container gracefully", zap.String("name", name), zap.Error(err))
 	}
+	return forceRemoveContainer(name)
+}
 
+// Stops and removes a container with the given name. A missing container is
+// not an error.
+func stopContainer(name string) error {
 	zap.L().Info("Stopping container", zap.String("name", name))
-	cmd := exec.Command("docker", "compose", "-f", composeFile, "rm", "-sf", name)
-	output, err := cmd.CombinedOutput()
-	if len(output) > 0 {
-		zap.L().Info("docker compose output", zap.String("output", string(output)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerStopTimeout+dockerTimeout)
+	defer cancel()
+
+	err := containerRuntime.ContainerStop(ctx, name, dockerStopTimeout)
+	if err == nil {
+		err = containerRuntime.ContainerRemove(ctx, name, true)
+	}
+	if docker.IsNotFound(err) {
+		return nil
 	}
 	if err != nil {
-		return fmt.Errorf("failed to stop container %s: %w", name, err)
+		return err
 	}
 
 	zap.L().Info("Container stopped successfully", zap.String("name", name))
 	return nil
 }
 
+// inspectContainer returns the state of a container by name
+func inspectContainer(name string) (*docker.ContainerInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+	return containerRuntime.ContainerInspect(ctx, name)
+}
+
 // Checks if a container with the given name exists (including stopped/exited)
 func containerExists(name string) bool {
-	cmd := exec.Command("docker", "ps", "-a", "--filter", "name=^/"+name+"$", "--format", "{{.Names}}")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return false
-	}
-	return strings.TrimSpace(string(output)) == name
+	_, err := inspectContainer(name)
+	return err == nil
+}
+
+// ContainerRunning checks if a container with the given name is running
+func ContainerRunning(name string) bool {
+	info, err := inspectContainer(name)
+	return err == nil && info.State.Running
 }
 
 // Checks if a docker network with the given name exists
 func networkExists(name string) bool {
-	cmd := exec.Command("docker", "network", "ls", "--filter", "name=^"+name+"$", "--format", "{{.Name}}")
-	output, err := cmd.CombinedOutput()
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+
+	network, err := containerRuntime.NetworkInspect(ctx, name)
+	// inspecting also matches ID prefixes
+	return err == nil && network.Name == name
+}
+
+// Runs a command inside a running container and returns its combined output
+func execInContainer(name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+
+	output, exitCode, err := containerRuntime.Exec(ctx, name, args)
+	if err != nil {
+		return output, err
+	}
+	if exitCode != 0 {
+		return output, fmt.Errorf("command in container %s exited with %d: %s", name, exitCode, strings.TrimSpace(output))
+	}
+	return output, nil
+}
+
+// Runs a one-off container of a service's image on the given network that
+// is removed afterwards and returns its combined output. env holds
+// "KEY=value" entries.
+func runThrowawayContainer(service string, network string, volumes []string, env []string, entrypoint string, args ...string) (string, error) {
+	image, err := provideImage(service)
 	if err != nil {
-		return false
+		return "", err
 	}
-	return strings.TrimSpace(string(output)) == name
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerRunTimeout)
+	defer cancel()
+
+	output, exitCode, err := docker.Run(ctx, containerRuntime, docker.ContainerSpec{
+		Image:       image,
+		Entrypoint:  []string{entrypoint},
+		Cmd:         args,
+		Env:         env,
+		Binds:       volumes,
+		NetworkMode: network,
+	})
+	if err != nil {
+		return output, fmt.Errorf("throwaway container %s failed: %w", image, err)
+	}
+	if exitCode != 0 {
+		return output, fmt.Errorf("throwaway container %s exited with %d: %s", image, exitCode, strings.TrimSpace(output))
+	}
+	return output, nil
+}
+
+// Returns the healthcheck status of a container ("starting", "healthy", "unhealthy"),
+// or its state if it has no healthcheck
+func containerHealth(name string) (string, error) {
+	info, err := inspectContainer(name)
+	if err != nil {
+		return "", err
+	}
+	return info.HealthStatus(), nil
+}
+
+// Waits until a container reports healthy or the timeout expires
+func waitForHealthy(name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	status := ""
+	for time.Now().Before(deadline) {
+		var err error
+		status, err = containerHealth(name)
+		if err == nil && (status == "healthy" || status == "unhealthy") {
+			break
+		}
+		time.Sleep(2 * time.Second)
+	}
+	if status != "healthy" {
+		return fmt.Errorf("container %s did not become healthy within %s (last status %q)", name, timeout, status)
+	}
+	return nil
 }
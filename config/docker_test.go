@@ -0,0 +1,21 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/docker"
+)
+
+// useFakeRuntime runs the containers of a test on an in-memory runtime
+func useFakeRuntime(t *testing.T) *docker.Fake {
+	t.Helper()
+	previous := containerRuntime
+	fake := docker.NewFake()
+	SetContainerRuntime(fake)
+	t.Cleanup(func() { SetContainerRuntime(previous) })
+	return fake
+}
+
+// preflightOutput answers the Elasticsearch preflight check of
+// checkElasticsearchTargetInContainer with a matching index
+const preflightOutput = esPreflightMarker + " 200 0\n" + `[{"index":"logstash-2025.06.01"}]`
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/assets"
+	"go.uber.org/zap"
+)
+
+// how long the checks of one target may take
+const esPreflightTimeout = 15 * time.Second
+
+var (
+	esPreflightMu       sync.Mutex
+	esPreflightFailures = map[string]string{}
+)
+
+// ElasticsearchPreflightFailures returns the targets skipped by the last
+// preflight, by URL, with the reason
+func ElasticsearchPreflightFailures() map[string]string {
+	esPreflightMu.Lock()
+	defer esPreflightMu.Unlock()
+	return maps.Clone(esPreflightFailures)
+}
+
+func setPreflightFailures(failures map[string]string) {
+	esPreflightMu.Lock()
+	esPreflightFailures = failures
+	esPreflightMu.Unlock()
+}
+
+// preflightElasticsearchTargets checks all targets and reports by position
+// which ones passed. Failing targets are logged and recorded individually.
+func preflightElasticsearchTargets(c *Config, targets []ElasticsearchTarget) []bool {
+	passed := make([]bool, len(targets))
+	failures := map[string]string{}
+
+	for i, target := range targets {
+		if err := checkElasticsearchTarget(c, target); err != nil {
+			zap.L().Warn("Skipping Elasticsearch target that failed the preflight check",
+				zap.String("url", target.URL),
+				zap.Error(err),
+			)
+			failures[target.URL] = err.Error()
+			continue
+		}
+		zap.L().Info("Elasticsearch target passed the preflight check", zap.String("url", target.URL))
+		passed[i] = true
+	}
+
+	setPreflightFailures(failures)
+	return passed
+}
+
+// checkElasticsearchTarget verifies that a target resolves, accepts
+// connections and the credentials, and has indices matching its pattern. The
+// checks run where the target will be polled from: in-process for the native
+// poller, otherwise in a container on the logstash network.
+func checkElasticsearchTarget(c *Config, target ElasticsearchTarget) error {
+	if c.NativePoller() {
+		return checkElasticsearchTargetLocal(c, target)
+	}
+	return checkElasticsearchTargetInContainer(c, target)
+}
+
+func checkElasticsearchTargetLocal(c *Config, target ElasticsearchTarget) error {
+	u, err := url.Parse(target.URL)
+	if err != nil || u.Hostname() == "" {
+		return fmt.Errorf("invalid url %q", target.URL)
+	}
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), esPreflightTimeout)
+	defer cancel()
+
+	if net.ParseIP(host) == nil {
+		if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+			return fmt.Errorf("dns: cannot resolve %s: %w", host, err)
+		}
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return fmt.Errorf("tcp: cannot connect to %s: %w", net.JoinHostPort(host, port), err)
+	}
+	conn.Close()
+
+	t, err := newESPollTarget(c, target)
+	if err != nil {
+		return err
+	}
+	var indices []struct {
+		Index string `json:"index"`
+	}
+	err = t.do(ctx, http.MethodGet, esIndicesPath(t.index), nil, &indices)
+
+	var httpErr *esHTTPError
+	switch {
+	case errors.As(err, &httpErr):
+		return classifyESStatus(httpErr.StatusCode, t.index, err)
+	case err != nil:
+		return fmt.Errorf("request failed: %w", err)
+	case len(indices) == 0:
+		return fmt.Errorf("index: no index matches %s", t.index)
+	}
+	return nil
+}
+
+// marks the result line of the check script in the container output
+const esPreflightMarker = "NFG_PREFLIGHT"
+
+// esPreflightScript queries the indices with curl and prints the HTTP status
+// and curl exit code, followed by the response body
+const esPreflightScript = `code=$(curl -s -o /tmp/body -w '%{http_code}' --connect-timeout 5 -m 10 $ES_CURL_OPTS -H "Authorization: $ES_AUTH" "$ES_URL"); rc=$?; echo "` + esPreflightMarker + ` $code $rc"; cat /tmp/body 2>/dev/null`
+
+func checkElasticsearchTargetInContainer(c *Config, target ElasticsearchTarget) error {
+	index := esTargetIndex(c, target)
+	env := []string{
+		"ES_URL=" + strings.TrimSuffix(target.URL, "/") + esIndicesPath(index),
+		"ES_AUTH=" + esAuthHeader(target),
+	}
+	var volumes []string
+	switch {
+	case target.VerificationMode == "none" || target.CATrustedFingerprint != "":
+		// the fingerprint is checked by logstash, here only reachability matters
+		env = append(env, "ES_CURL_OPTS=-k")
+	case target.CAFile != "":
+		abs, err := filepath.Abs(target.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to resolve CA file path: %w", err)
+		}
+		volumes = append(volumes, abs+":"+logstashCAPath(target.CAFile)+":ro")
+		env = append(env, "ES_CURL_OPTS=--cacert "+logstashCAPath(target.CAFile))
+	}
+
+	output, err := runThrowawayContainer("nfg-logstash", assets.LogstashNetworkMode(c.LogstashNetwork), volumes, env, "bash", "-c", esPreflightScript)
+	_, result, found := strings.Cut(output, esPreflightMarker+" ")
+	if !found {
+		// the check itself could not run, that's not the target's fault
+		zap.L().Warn("Elasticsearch preflight check could not run, not skipping target",
+			zap.String("url", target.URL),
+			zap.Error(err),
+		)
+		return nil
+	}
+
+	status, body, _ := strings.Cut(result, "\n")
+	fields := strings.Fields(status)
+	if len(fields) != 2 {
+		return fmt.Errorf("unexpected preflight output %q", status)
+	}
+	code, _ := strconv.Atoi(fields[0])
+	rc, _ := strconv.Atoi(fields[1])
+
+	switch rc {
+	case 0:
+	case 6:
+		return fmt.Errorf("dns: cannot resolve host of %s", target.URL)
+	case 7:
+		return fmt.Errorf("tcp: cannot connect to %s", target.URL)
+	case 28:
+		return fmt.Errorf("tcp: timed out connecting to %s", target.URL)
+	case 35, 51, 53, 54, 58, 59, 60, 64, 66, 77, 80, 82, 83, 90, 91:
+		return fmt.Errorf("tls: handshake or certificate verification failed (curl exit %d)", rc)
+	default:
+		return fmt.Errorf("request failed (curl exit %d)", rc)
+	}
+
+	if code >= 300 {
+		return classifyESStatus(code, index, fmt.Errorf("HTTP %d: %s", code, strings.TrimSpace(body)))
+	}
+
+	var indices []struct {
+		Index string `json:"index"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(body)), &indices); err != nil {
+		return fmt.Errorf("unexpected response: %w", err)
+	}
+	if len(indices) == 0 {
+		return fmt.Errorf("index: no index matches %s", index)
+	}
+	return nil
+}
+
+// classifyESStatus names the check an Elasticsearch error status belongs to
+func classifyESStatus(code int, index string, err error) error {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("auth: %w", err)
+	case http.StatusNotFound:
+		return fmt.Errorf("index: no index matches %s", index)
+	default:
+		return fmt.Errorf("request failed: %w", err)
+	}
+}
+
+// esIndicesPath lists the indices matching a pattern
+func esIndicesPath(index string) string {
+	return "/_cat/indices/" + url.PathEscape(index) + "?format=json&h=index"
+}
+
+// esTargetIndex returns the index pattern polled on a target
+func esTargetIndex(c *Config, target ElasticsearchTarget) string {
+	if target.Index != "" {
+		return target.Index
+	}
+	return c.LogstashIndexPattern
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+
+	"go.uber.org/zap"
+)
+
+// credentialsKeyInfo derives the key for target credentials from the auth secret
+const credentialsKeyInfo = "nfgtfa elasticsearch credentials v1"
+
+// EffectiveElasticsearchTargets returns the local targets followed by the
+// ones managed by the controller. A local target replaces a controller
+// target with the same URL and index.
+func (c *Config) EffectiveElasticsearchTargets() []ElasticsearchTarget {
+	targets := append([]ElasticsearchTarget{}, c.ElasticsearchTargets...)
+	local := map[string]bool{}
+	for _, target := range c.ElasticsearchTargets {
+		local[esTargetKey(target)] = true
+	}
+	for _, target := range c.RemoteElasticsearchTargets {
+		if !local[esTargetKey(target)] {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// decryptRemoteTargets turns the targets sent by the controller into
+// ElasticsearchTargets. Targets whose credentials can't be decrypted are
+// skipped. Plain text secrets and host paths from the controller are ignored.
+func decryptRemoteTargets(c *Config, remote []RemoteElasticsearchTarget) []ElasticsearchTarget {
+	var targets []ElasticsearchTarget
+	for _, r := range remote {
+		target := r.ElasticsearchTarget
+		target.Password = ""
+		target.APIKey = ""
+		if target.CAFile != "" {
+			zap.L().Warn("Ignoring ca_file of controller-managed Elasticsearch target, use ca_trusted_fingerprint instead",
+				zap.String("url", target.URL),
+			)
+			target.CAFile = ""
+		}
+
+		if r.Credentials != "" {
+			creds, err := decryptCredentials(c, target.URL, r.Credentials)
+			if err != nil {
+				zap.L().Error("Skipping controller-managed Elasticsearch target", zap.String("url", target.URL), zap.Error(err))
+				continue
+			}
+			target.Password = creds.Password
+			target.APIKey = creds.APIKey
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// decryptCredentials opens the sealed credentials of a controller-managed
+// target, see RemoteElasticsearchTarget for the format
+func decryptCredentials(c *Config, url string, sealed string) (targetCredentials, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return targetCredentials{}, fmt.Errorf("invalid credentials encoding: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(c.AuthSecret))
+	mac.Write([]byte(credentialsKeyInfo))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return targetCredentials{}, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return targetCredentials{}, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return targetCredentials{}, fmt.Errorf("credentials too short")
+	}
+
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, []byte(c.AggregatorName+"|"+url))
+	if err != nil {
+		return targetCredentials{}, fmt.Errorf("failed to decrypt credentials: %w", err)
+	}
+
+	var creds targetCredentials
+	if err := json.Unmarshal(plain, &creds); err != nil {
+		return targetCredentials{}, fmt.Errorf("invalid credentials: %w", err)
+	}
+	return creds, nil
+}
+
+type targetCredentials struct {
+	Password string `json:"pass,omitempty"`
+	APIKey   string `json:"api_key,omitempty"`
+}
+
+// logstashContainerSpec is what a change of targets can alter in the
+// nfg-logstash container itself, beyond its pipeline
+type logstashContainerSpec struct {
+	secrets    map[string]string
+	caFiles    map[string]string
+	opensearch bool
+}
+
+func currentLogstashContainerSpec(c *Config) logstashContainerSpec {
+	return logstashContainerSpec{
+		secrets:    maps.Clone(logstashSecrets(c)),
+		caFiles:    logstashCAFiles(c),
+		opensearch: usesOpenSearch(c),
+	}
+}
+
+func (s logstashContainerSpec) equal(other logstashContainerSpec) bool {
+	return reflect.DeepEqual(s, other)
+}
@@ -0,0 +1,648 @@
+package config
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/models"
+	"go.uber.org/zap"
+)
+
+// Ways to poll the Elasticsearch targets
+const (
+	logstashModeContainer = "container" // logstash in the nfg-logstash container
+	logstashModeNative    = "native"    // in-process poller, no container
+)
+
+// NativePoller reports whether Elasticsearch is polled in-process instead
+// of by the nfg-logstash container
+func (c *Config) NativePoller() bool {
+	return c.LogstashMode == logstashModeNative
+}
+
+// documents younger than this may still be indexing and are left for the
+// next poll, like the :present bound of the logstash input
+const esPollLag = 30 * time.Second
+
+// how long a point in time is kept open between two pages
+const esPITKeepAlive = "1m"
+
+// esTimeFormat is the format of window bounds in checkpoints and range queries
+const esTimeFormat = "2006-01-02T15:04:05.000Z"
+
+var (
+	pollerMu sync.Mutex
+	poller   *nativePoller
+)
+
+// nativePoller polls all Elasticsearch targets until it is stopped
+type nativePoller struct {
+	cancel  context.CancelFunc
+	done    chan struct{}
+	maxIdle time.Duration         // a target without a successful poll for longer is unhealthy
+	filter  models.LogstashFilter // filter changes restart the poller
+
+	mu          sync.Mutex
+	lastSuccess map[string]time.Time
+}
+
+// esCheckpoint is the progress of one target. Documents up to From are
+// delivered, the window (From, To] is in progress up to After. Sort values
+// of a search are only valid in its point in time, so after a restart the
+// window resumes at After and skips the documents in AfterIDs.
+type esCheckpoint struct {
+	From     string   `json:"from"`
+	To       string   `json:"to,omitempty"`
+	After    string   `json:"after,omitempty"`    // tracking field of the last delivered document
+	AfterIDs []string `json:"afterIds,omitempty"` // delivered documents with that tracking field, "<index>/<id>"
+}
+
+// esPollTarget holds everything needed to poll one target
+type esPollTarget struct {
+	target     ElasticsearchTarget
+	flavor     string
+	index      string
+	client     *http.Client
+	checkpoint string
+}
+
+// restartNativePoller stops a running poller and starts a new one with the
+// current config
+func restartNativePoller(c *Config) error {
+	StopNativePoller()
+
+	var targets []esPollTarget
+	candidates := c.EffectiveElasticsearchTargets()
+	passed := preflightElasticsearchTargets(c, candidates)
+	for i, target := range candidates {
+		if !passed[i] {
+			continue
+		}
+		t, err := newESPollTarget(c, target)
+		if err != nil {
+			zap.L().Warn("Skipping invalid Elasticsearch target", zap.String("url", target.URL), zap.Error(err))
+			continue
+		}
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("no valid ELK targets found...")
+	}
+
+	collector, err := c.HTTPClient()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.LogstashStateDir, 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &nativePoller{
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		maxIdle:     max(3*c.LogstashPollInterval, 2*time.Minute),
+		filter:      c.LogstashFilter,
+		lastSuccess: map[string]time.Time{},
+	}
+
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.run(ctx, c, t, collector)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(p.done)
+	}()
+
+	pollerMu.Lock()
+	poller = p
+	pollerMu.Unlock()
+
+	zap.L().Info("Native Elasticsearch poller started", zap.Int("targets", len(targets)))
+	return nil
+}
+
+// StopNativePoller stops the native poller, if running, and waits for the
+// batches in flight
+func StopNativePoller() {
+	pollerMu.Lock()
+	p := poller
+	poller = nil
+	pollerMu.Unlock()
+
+	if p == nil {
+		return
+	}
+	p.cancel()
+	<-p.done
+	zap.L().Info("Native Elasticsearch poller stopped")
+}
+
+// NativePollerStatus reports whether the native poller runs, and why it is
+// unhealthy: one reason per target that wasn't polled successfully recently
+func NativePollerStatus() (running bool, reasons []string) {
+	pollerMu.Lock()
+	p := poller
+	pollerMu.Unlock()
+
+	if p == nil {
+		return false, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for url, last := range p.lastSuccess {
+		if time.Since(last) > p.maxIdle {
+			reasons = append(reasons, fmt.Sprintf("%s not polled successfully since %s", url, last.Format(time.RFC3339)))
+		}
+	}
+	slices.Sort(reasons)
+	return true, reasons
+}
+
+func (p *nativePoller) markSuccess(url string) {
+	p.mu.Lock()
+	p.lastSuccess[url] = time.Now()
+	p.mu.Unlock()
+}
+
+// run polls one target every LogstashPollInterval until ctx is cancelled
+func (p *nativePoller) run(ctx context.Context, c *Config, t esPollTarget, collector *http.Client) {
+	// count the start as success so the grace period starts now
+	p.markSuccess(t.target.URL)
+
+	ticker := time.NewTicker(c.LogstashPollInterval)
+	defer ticker.Stop()
+	for {
+		err := pollOnce(ctx, c, t, p.filter, collector)
+		switch {
+		case err == nil:
+			p.markSuccess(t.target.URL)
+		case ctx.Err() != nil:
+			return
+		default:
+			zap.L().Warn("Polling Elasticsearch target failed", zap.String("url", t.target.URL), zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollOnce forwards all documents of the current window of a target
+func pollOnce(ctx context.Context, c *Config, t esPollTarget, filter models.LogstashFilter, collector *http.Client) error {
+	cp, err := loadESCheckpoint(t.checkpoint)
+	if err != nil {
+		return err
+	}
+	if cp.To == "" {
+		to := time.Now().UTC().Add(-esPollLag).Format(esTimeFormat)
+		if to <= cp.From {
+			return nil
+		}
+		cp = esCheckpoint{From: cp.From, To: to}
+	}
+
+	field := c.LogstashTrackingField
+	window := map[string]any{"lte": cp.To, "format": "strict_date_optional_time"}
+	if cp.After != "" {
+		window["gte"] = cp.After
+	} else {
+		window["gt"] = cp.From
+	}
+
+	pit, err := t.openPIT(ctx)
+	if err != nil {
+		return err
+	}
+	// searches may return a new id, close whichever is current at the end
+	defer func() { t.closePIT(pit) }()
+
+	var searchAfter []any
+	for {
+		query := map[string]any{
+			"size":  c.LogstashPageSize,
+			"pit":   map[string]any{"id": pit, "keep_alive": esPITKeepAlive},
+			"query": map[string]any{"range": map[string]any{field: window}},
+			// sort values in milliseconds, also for date_nanos fields
+			"sort": []any{
+				map[string]any{field: map[string]any{"order": "asc", "numeric_type": "date"}},
+				map[string]any{t.tiebreaker(): "asc"},
+			},
+		}
+		if searchAfter != nil {
+			query["search_after"] = searchAfter
+		}
+
+		var resp struct {
+			PitID string `json:"pit_id"`
+			Hits  struct {
+				Hits []struct {
+					Index  string         `json:"_index"`
+					ID     string         `json:"_id"`
+					Source map[string]any `json:"_source"`
+					Sort   []any          `json:"sort"`
+				} `json:"hits"`
+			} `json:"hits"`
+		}
+		if err := t.do(ctx, http.MethodPost, "/_search", query, &resp); err != nil {
+			return fmt.Errorf("search failed: %w", err)
+		}
+		if resp.PitID != "" {
+			pit = resp.PitID
+		}
+
+		hits := resp.Hits.Hits
+		if len(hits) == 0 {
+			break
+		}
+
+		next := cp
+		var events []map[string]any
+		for _, hit := range hits {
+			tracked, err := esSortTime(hit.Sort)
+			if err != nil {
+				return err
+			}
+			id := hit.Index + "/" + hit.ID
+			if tracked == cp.After && slices.Contains(cp.AfterIDs, id) {
+				// delivered before the poller was restarted
+				continue
+			}
+			if event, ok := applyLogstashFilter(filter, hit.Source); ok {
+				events = append(events, event)
+			}
+			if tracked != next.After {
+				next.After = tracked
+				next.AfterIDs = nil
+			}
+			next.AfterIDs = append(next.AfterIDs, id)
+		}
+		if len(events) > 0 {
+			if err := postEvents(ctx, c, collector, events); err != nil {
+				return err
+			}
+		}
+
+		cp = next
+		if err := saveESCheckpoint(t.checkpoint, cp); err != nil {
+			return err
+		}
+		zap.L().Debug("Forwarded Elasticsearch documents",
+			zap.String("url", t.target.URL),
+			zap.Int("documents", len(hits)),
+			zap.Int("events", len(events)),
+		)
+
+		if len(hits) < c.LogstashPageSize {
+			break
+		}
+		searchAfter = hits[len(hits)-1].Sort
+	}
+
+	return saveESCheckpoint(t.checkpoint, esCheckpoint{From: cp.To})
+}
+
+// esSortTime returns the tracking field of a hit from its sort values, in
+// the format of the window bounds
+func esSortTime(sort []any) (string, error) {
+	if len(sort) == 0 {
+		return "", fmt.Errorf("search hit without sort values")
+	}
+	n, ok := sort[0].(json.Number)
+	if !ok {
+		return "", fmt.Errorf("unexpected sort value %v, is the tracking field a date?", sort[0])
+	}
+	ms, err := n.Int64()
+	if err != nil {
+		return "", fmt.Errorf("unexpected sort value %v: %w", sort[0], err)
+	}
+	return time.UnixMilli(ms).UTC().Format(esTimeFormat), nil
+}
+
+// applyLogstashFilter applies the controller filter to a document like the
+// filter section of the logstash pipeline. It reports false for documents
+// that are dropped.
+func applyLogstashFilter(f models.LogstashFilter, doc map[string]any) (map[string]any, bool) {
+	if len(f.HoneypotTypes) > 0 {
+		honeypot, _ := doc["type"].(string)
+		if !slices.Contains(f.HoneypotTypes, honeypot) {
+			return nil, false
+		}
+	}
+
+	event := map[string]any{}
+	for field, value := range doc {
+		required := slices.Contains(logstashRequiredFields, field)
+		if len(f.IncludeFields) > 0 && !required && !slices.Contains(f.IncludeFields, field) {
+			continue
+		}
+		if slices.Contains(f.ExcludeFields, field) && !required {
+			continue
+		}
+		event[field] = value
+	}
+	// logstash adds @version to every event
+	if _, ok := event["@version"]; !ok {
+		event["@version"] = "1"
+	}
+	return event, true
+}
+
+// postEvents sends a batch of events to the collector as a gzipped JSON
+// array. Connection errors and retryable responses are retried with backoff
+// until the collector accepts the batch or ctx is cancelled.
+func postEvents(ctx context.Context, c *Config, client *http.Client, events []map[string]any) error {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := json.NewEncoder(gz).Encode(events); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	backoff := time.Second
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.NfgThreatCollectorUrl+"/t-pot", bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set("X-AUTH_KEY", c.AuthSecret)
+		req.Header.Set("X-AGGREGATOR_NAME", c.AggregatorName)
+
+		resp, err := client.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			switch {
+			case resp.StatusCode < 300:
+				return nil
+			case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+				err = fmt.Errorf("collector responded with %s", resp.Status)
+			default:
+				// retrying won't help, skip the batch like logstash does
+				zap.L().Error("Collector rejected events, dropping batch",
+					zap.String("status", resp.Status),
+					zap.Int("events", len(events)),
+				)
+				return nil
+			}
+		}
+
+		zap.L().Warn("Failed to post events to collector, retrying",
+			zap.Error(err),
+			zap.Duration("backoff", backoff),
+		)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+// newESPollTarget validates a target and prepares its client
+func newESPollTarget(c *Config, target ElasticsearchTarget) (esPollTarget, error) {
+	if target.URL == "" {
+		return esPollTarget{}, fmt.Errorf("missing url")
+	}
+	if target.User == "" && target.APIKey == "" {
+		return esPollTarget{}, fmt.Errorf("missing user or api_key")
+	}
+	flavor := target.Flavor
+	if flavor == "" {
+		flavor = flavorElasticsearch
+	}
+	if flavor != flavorElasticsearch && flavor != flavorOpenSearch {
+		return esPollTarget{}, fmt.Errorf("unsupported flavor %q", target.Flavor)
+	}
+	if target.VerificationMode != "" && target.VerificationMode != "full" && target.VerificationMode != "none" {
+		return esPollTarget{}, fmt.Errorf("unsupported verification_mode %q", target.VerificationMode)
+	}
+
+	tlsConfig, err := esTLSConfig(target)
+	if err != nil {
+		return esPollTarget{}, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return esPollTarget{
+		target: target,
+		flavor: flavor,
+		index:  esTargetIndex(c, target),
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Minute,
+		},
+		checkpoint: filepath.Join(c.LogstashStateDir, fmt.Sprintf("%s.json", esTargetKey(target))),
+	}, nil
+}
+
+// esTLSConfig builds the TLS settings of a target from its CA file,
+// trusted fingerprint and verification mode
+func esTLSConfig(target ElasticsearchTarget) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if target.CAFile != "" {
+		pem, err := os.ReadFile(target.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file %s: %w", target.CAFile, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", target.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if target.VerificationMode == "none" {
+		tlsConfig.InsecureSkipVerify = true
+	} else if target.CATrustedFingerprint != "" {
+		// trust the chain if any of its certificates has the fingerprint
+		want := strings.ToLower(strings.ReplaceAll(target.CATrustedFingerprint, ":", ""))
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			for _, raw := range rawCerts {
+				sum := sha256.Sum256(raw)
+				if hex.EncodeToString(sum[:]) == want {
+					return nil
+				}
+			}
+			return errors.New("no certificate matches ca_trusted_fingerprint")
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// tiebreaker returns the field that orders documents with the same tracking
+// field. OpenSearch has no implicit tiebreaker for point in time searches
+// and no _shard_doc, so it sorts by _id.
+func (t esPollTarget) tiebreaker() string {
+	if t.flavor == flavorOpenSearch {
+		return "_id"
+	}
+	return "_shard_doc"
+}
+
+// openPIT opens a point in time on the index of the target
+func (t esPollTarget) openPIT(ctx context.Context) (string, error) {
+	index := url.PathEscape(t.index)
+	if t.flavor == flavorOpenSearch {
+		var resp struct {
+			PitID string `json:"pit_id"`
+		}
+		if err := t.do(ctx, http.MethodPost, "/"+index+"/_search/point_in_time?keep_alive="+esPITKeepAlive, nil, &resp); err != nil {
+			return "", fmt.Errorf("failed to open point in time: %w", err)
+		}
+		return resp.PitID, nil
+	}
+
+	var resp struct {
+		ID string `json:"id"`
+	}
+	if err := t.do(ctx, http.MethodPost, "/"+index+"/_pit?keep_alive="+esPITKeepAlive, nil, &resp); err != nil {
+		return "", fmt.Errorf("failed to open point in time: %w", err)
+	}
+	return resp.ID, nil
+}
+
+// closePIT releases a point in time, it expires by itself if this fails
+func (t esPollTarget) closePIT(pit string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var err error
+	if t.flavor == flavorOpenSearch {
+		err = t.do(ctx, http.MethodDelete, "/_search/point_in_time", map[string]any{"pit_id": []string{pit}}, nil)
+	} else {
+		err = t.do(ctx, http.MethodDelete, "/_pit", map[string]any{"id": pit}, nil)
+	}
+	if err != nil {
+		zap.L().Debug("Failed to close point in time", zap.String("url", t.target.URL), zap.Error(err))
+	}
+}
+
+// do sends a JSON request to the target and decodes the response into out
+func (t esPollTarget) do(ctx context.Context, method string, path string, in any, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(t.target.URL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", esAuthHeader(t.target))
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &esHTTPError{
+			StatusCode: resp.StatusCode,
+			msg:        fmt.Sprintf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg))),
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	return dec.Decode(out)
+}
+
+// esHTTPError is returned for Elasticsearch responses other than 2xx
+type esHTTPError struct {
+	StatusCode int
+	msg        string
+}
+
+func (e *esHTTPError) Error() string {
+	return e.msg
+}
+
+// esAuthHeader returns the Authorization header of a target
+func esAuthHeader(target ElasticsearchTarget) string {
+	if target.APIKey != "" {
+		return "ApiKey " + base64.StdEncoding.EncodeToString([]byte(target.APIKey))
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(target.User+":"+target.Password))
+}
+
+// loadESCheckpoint reads the checkpoint of a target. Targets without one
+// start at logstashSeed, so only new documents are forwarded.
+func loadESCheckpoint(path string) (esCheckpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return esCheckpoint{From: logstashSeed}, nil
+	}
+	if err != nil {
+		return esCheckpoint{}, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var cp esCheckpoint
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&cp); err != nil {
+		return esCheckpoint{}, fmt.Errorf("failed to parse checkpoint %s: %w", path, err)
+	}
+	return cp, nil
+}
+
+// saveESCheckpoint replaces the checkpoint of a target atomically
+func saveESCheckpoint(path string, cp esCheckpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,296 @@
+package config
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/models"
+)
+
+// esTestDoc is a document of the fake Elasticsearch
+type esTestDoc struct {
+	id        string
+	timestamp string
+	source    map[string]any
+}
+
+// esTestServer is an Elasticsearch with one index that answers point in
+// time searches sorted by @timestamp and the position of the document
+type esTestServer struct {
+	*httptest.Server
+	t          *testing.T
+	flavor     string
+	tiebreaker string
+	docs       []esTestDoc
+
+	mu       sync.Mutex
+	searches []map[string]any
+}
+
+func newESTestServer(t *testing.T, flavor string, docs []esTestDoc) *esTestServer {
+	s := &esTestServer{t: t, flavor: flavor, tiebreaker: "_shard_doc", docs: docs}
+	openPath, closePath := "/logstash-*/_pit", "/_pit"
+	if flavor == flavorOpenSearch {
+		s.tiebreaker = "_id"
+		openPath, closePath = "/logstash-*/_search/point_in_time", "/_search/point_in_time"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+openPath, func(w http.ResponseWriter, r *http.Request) {
+		if flavor == flavorOpenSearch {
+			io.WriteString(w, `{"pit_id":"pit"}`)
+		} else {
+			io.WriteString(w, `{"id":"pit"}`)
+		}
+	})
+	mux.HandleFunc("DELETE "+closePath, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{}`)
+	})
+	mux.HandleFunc("POST /_search", s.search)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *esTestServer) search(w http.ResponseWriter, r *http.Request) {
+	var query map[string]any
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&query); err != nil {
+		s.t.Errorf("invalid search: %v", err)
+		return
+	}
+	s.mu.Lock()
+	s.searches = append(s.searches, query)
+	s.mu.Unlock()
+
+	sort, _ := json.Marshal(query["sort"])
+	want := `[{"@timestamp":{"numeric_type":"date","order":"asc"}},{"` + s.tiebreaker + `":"asc"}]`
+	if string(sort) != want {
+		s.t.Errorf("sort = %s, want %s", sort, want)
+	}
+
+	window := query["query"].(map[string]any)["range"].(map[string]any)["@timestamp"].(map[string]any)
+	size, _ := query["size"].(json.Number).Int64()
+	after := int64(-1)
+	if sa, ok := query["search_after"].([]any); ok {
+		after, _ = sa[1].(json.Number).Int64()
+	}
+
+	var hits []map[string]any
+	for i, doc := range s.docs {
+		if gt, ok := window["gt"].(string); ok && doc.timestamp <= gt {
+			continue
+		}
+		if gte, ok := window["gte"].(string); ok && doc.timestamp < gte {
+			continue
+		}
+		if doc.timestamp > window["lte"].(string) || int64(i) <= after || int64(len(hits)) >= size {
+			continue
+		}
+		ts, _ := time.Parse(esTimeFormat, doc.timestamp)
+		hits = append(hits, map[string]any{
+			"_index":  "logstash-2025.06.01",
+			"_id":     doc.id,
+			"_source": doc.source,
+			"sort":    []any{ts.UnixMilli(), i},
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]any{"pit_id": "pit", "hits": map[string]any{"hits": hits}})
+}
+
+// esTestCollector records the batches posted to /t-pot. Posts fail with
+// 503 once fail batches are accepted.
+type esTestCollector struct {
+	*httptest.Server
+	fail int
+
+	mu      sync.Mutex
+	batches [][]map[string]any
+}
+
+func newESTestCollector(t *testing.T, fail int) *esTestCollector {
+	c := &esTestCollector{fail: fail}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/t-pot" || r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("unexpected post %s with Content-Encoding %q", r.URL.Path, r.Header.Get("Content-Encoding"))
+			return
+		}
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("body is not gzipped: %v", err)
+			return
+		}
+		var batch []map[string]any
+		if err := json.NewDecoder(gz).Decode(&batch); err != nil {
+			t.Errorf("body is not a JSON array: %v", err)
+			return
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.fail > 0 && len(c.batches) >= c.fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		c.batches = append(c.batches, batch)
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+// eventIDs returns the documents of the accepted batches, one list per batch
+func (c *esTestCollector) eventIDs() [][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ids [][]string
+	for _, batch := range c.batches {
+		var batchIDs []string
+		for _, event := range batch {
+			batchIDs = append(batchIDs, event["doc"].(string))
+		}
+		ids = append(ids, batchIDs)
+	}
+	return ids
+}
+
+func esPollTestSetup(t *testing.T, flavor string, docs []esTestDoc, fail int) (*Config, esPollTarget, *esTestServer, *esTestCollector) {
+	seed := logstashSeed
+	logstashSeed = "2025-06-01T12:00:00.000Z"
+	t.Cleanup(func() { logstashSeed = seed })
+
+	es := newESTestServer(t, flavor, docs)
+	collector := newESTestCollector(t, fail)
+	c := &Config{
+		AggregatorName:        "tpot-01",
+		NfgThreatCollectorUrl: collector.URL,
+		LogstashIndexPattern:  "logstash-*",
+		LogstashPageSize:      2,
+		LogstashTrackingField: "@timestamp",
+		LogstashStateDir:      t.TempDir(),
+	}
+	target, err := newESPollTarget(c, ElasticsearchTarget{URL: es.URL, User: "elastic", Password: "secret", Flavor: flavor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, target, es, collector
+}
+
+func esTestDocs(docs ...string) []esTestDoc {
+	var out []esTestDoc
+	for _, doc := range docs {
+		id, timestamp, _ := strings.Cut(doc, "@")
+		out = append(out, esTestDoc{
+			id:        id,
+			timestamp: "2025-06-01T12:00:0" + timestamp + ".000Z",
+			source:    map[string]any{"doc": id, "type": "Cowrie"},
+		})
+	}
+	return out
+}
+
+func TestPollOnceForwardsWindow(t *testing.T) {
+	docs := esTestDocs("a@1", "b@2", "c@2", "d@3")
+	docs[2].source["type"] = "Dionaea"
+	c, target, es, collector := esPollTestSetup(t, flavorElasticsearch, docs, 0)
+
+	filter := models.LogstashFilter{HoneypotTypes: []string{"Cowrie"}}
+	if err := pollOnce(context.Background(), c, target, filter, collector.Client()); err != nil {
+		t.Fatal(err)
+	}
+
+	// one batch per page, c is dropped by the filter
+	if got, want := collector.eventIDs(), [][]string{{"a", "b"}, {"d"}}; !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("forwarded %v, want %v", got, want)
+	}
+	if len(es.searches) != 3 {
+		t.Fatalf("%d searches, want 3", len(es.searches))
+	}
+	if _, ok := es.searches[0]["search_after"]; ok {
+		t.Error("first search continues after a previous page")
+	}
+	if _, ok := es.searches[1]["search_after"]; !ok {
+		t.Error("second search doesn't continue after the first page")
+	}
+
+	cp, err := loadESCheckpoint(target.checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.To != "" || cp.After != "" || cp.From <= docs[3].timestamp {
+		t.Errorf("checkpoint = %+v, want the window to be done", cp)
+	}
+}
+
+func TestPollOnceResumesAfterRestart(t *testing.T) {
+	docs := esTestDocs("a@1", "b@2", "c@2", "d@3")
+	c, target, es, collector := esPollTestSetup(t, flavorOpenSearch, docs, 0)
+
+	// a and b were delivered before the restart, their sort values belong
+	// to a point in time that is gone
+	err := saveESCheckpoint(target.checkpoint, esCheckpoint{
+		From:     logstashSeed,
+		To:       "2025-06-01T12:00:09.000Z",
+		After:    docs[1].timestamp,
+		AfterIDs: []string{"logstash-2025.06.01/b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pollOnce(context.Background(), c, target, models.LogstashFilter{}, collector.Client()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := collector.eventIDs(), [][]string{{"c"}, {"d"}}; !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("forwarded %v, want %v", got, want)
+	}
+	window := es.searches[0]["query"].(map[string]any)["range"].(map[string]any)["@timestamp"].(map[string]any)
+	if window["gte"] != docs[1].timestamp || window["gt"] != nil {
+		t.Errorf("window = %v, want it to resume at %s", window, docs[1].timestamp)
+	}
+	if _, ok := es.searches[0]["search_after"]; ok {
+		t.Error("search_after of an old point in time was reused")
+	}
+}
+
+func TestPollOnceKeepsProgressOnCollectorError(t *testing.T) {
+	docs := esTestDocs("a@1", "b@1", "c@2", "d@3")
+	c, target, _, collector := esPollTestSetup(t, flavorElasticsearch, docs, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := pollOnce(ctx, c, target, models.LogstashFilter{}, collector.Client()); err == nil {
+		t.Fatal("poll succeeded while the collector was down")
+	}
+
+	cp, err := loadESCheckpoint(target.checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.After != docs[1].timestamp || !slices.Equal(cp.AfterIDs, []string{"logstash-2025.06.01/a", "logstash-2025.06.01/b"}) {
+		t.Errorf("checkpoint = %+v, want progress up to b", cp)
+	}
+}
+
+func TestESSortTime(t *testing.T) {
+	got, err := esSortTime([]any{json.Number("1748779201123"), json.Number("7")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2025-06-01T12:00:01.123Z"; got != want {
+		t.Errorf("esSortTime = %s, want %s", got, want)
+	}
+	if _, err := esSortTime([]any{"keyword"}); err == nil {
+		t.Error("no error for a sort value that isn't a date")
+	}
+}
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/assets"
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/docker"
+	"go.uber.org/zap"
+)
+
+// ImageStatus is the provisioning state of the image of a service
+type ImageStatus struct {
+	Image      string `json:"image"`
+	State      string `json:"state"`                // "pulling", "present" or "failed"
+	Downloaded int64  `json:"downloaded,omitempty"` // bytes, while pulling
+	Total      int64  `json:"total,omitempty"`      // bytes of the layers seen so far, while pulling
+	Error      string `json:"error,omitempty"`
+}
+
+const (
+	imagePulling = "pulling"
+	imagePresent = "present"
+	imageFailed  = "failed"
+)
+
+// registryAuth are the registry credentials by registry host, set up by Load
+var registryAuth map[string]docker.RegistryAuth
+
+var (
+	imageMu       sync.Mutex // one image is provided at a time
+	imageStatusMu sync.Mutex
+	imageStatuses = map[string]ImageStatus{}
+)
+
+// loadImageSettings applies the registry mirror or prefix, the image
+// overrides and the registry credentials from the environment
+func loadImageSettings() error {
+	opts := assets.ImageOptions{
+		Mirror:    getEnv("IMAGE_REGISTRY_MIRROR", ""),
+		Prefix:    getEnv("IMAGE_REGISTRY_PREFIX", ""),
+		Overrides: map[string]string{},
+	}
+	for _, service := range assets.Services() {
+		if ref := getEnv(imageOverrideEnv(service), ""); ref != "" {
+			opts.Overrides[service] = ref
+		}
+	}
+	if err := assets.SetImageOptions(opts); err != nil {
+		return err
+	}
+
+	auth := map[string]docker.RegistryAuth{}
+	if err := json.Unmarshal([]byte(getEnv("IMAGE_REGISTRY_AUTH", "{}")), &auth); err != nil {
+		return fmt.Errorf("failed to parse IMAGE_REGISTRY_AUTH: %w", err)
+	}
+	registryAuth = auth
+	return nil
+}
+
+// imageOverrideEnv is the variable overriding the image of a service, e.g.
+// IMAGE_NFG_SYSLOG
+func imageOverrideEnv(service string) string {
+	return "IMAGE_" + strings.ToUpper(strings.ReplaceAll(service, "-", "_"))
+}
+
+// ImageReport lists the images the services run as "service=image" pairs,
+// for the controller
+func ImageReport() string {
+	var pairs []string
+	for _, service := range assets.Services() {
+		if image, err := assets.ServiceImage(service); err == nil {
+			pairs = append(pairs, service+"="+image.String())
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+// ImageStatuses returns the provisioning state of the service images, by service
+func ImageStatuses() map[string]ImageStatus {
+	imageStatusMu.Lock()
+	defer imageStatusMu.Unlock()
+	return maps.Clone(imageStatuses)
+}
+
+func setImageStatus(service string, status ImageStatus) {
+	imageStatusMu.Lock()
+	imageStatuses[service] = status
+	imageStatusMu.Unlock()
+}
+
+// PullImages provides the images of the services up front, so they start
+// without waiting for a pull. Failures are logged, the services try again
+// when they start.
+func PullImages(c *Config) {
+	for _, service := range assets.Services() {
+		if service == "nfg-logstash" && c.NativePoller() {
+			continue
+		}
+		if image, err := assets.ServiceImage(service); err == nil && image.Digest == "" {
+			zap.L().Warn("Image is not pinned by digest, a moved tag changes what runs",
+				zap.String("service", service),
+				zap.String("image", image.String()),
+				zap.String("pinWith", imageOverrideEnv(service)),
+			)
+		}
+		if _, err := provideImage(service); err != nil {
+			zap.L().Error("Failed to provide image", zap.String("service", service), zap.Error(err))
+		}
+	}
+}
+
+// provideImage makes sure the image of a service is present and returns the
+// reference to run it by. Pinned images are pulled by digest and tagged, so
+// they keep their name when exported.
+func provideImage(service string) (string, error) {
+	image, err := assets.ServiceImage(service)
+	if err != nil {
+		return "", err
+	}
+
+	imageMu.Lock()
+	defer imageMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerPullTimeout)
+	defer cancel()
+
+	fail := func(err error) (string, error) {
+		setImageStatus(service, ImageStatus{Image: image.String(), State: imageFailed, Error: err.Error()})
+		return "", fmt.Errorf("failed to provide image %s: %w", image, err)
+	}
+
+	ref, found, err := localImage(ctx, image)
+	if err != nil {
+		return fail(err)
+	}
+	if found {
+		setImageStatus(service, ImageStatus{Image: image.String(), State: imagePresent})
+		return ref, nil
+	}
+
+	zap.L().Info("Pulling image", zap.String("service", service), zap.String("image", image.String()))
+	setImageStatus(service, ImageStatus{Image: image.String(), State: imagePulling})
+
+	layers := map[string]docker.PullProgress{}
+	progress := func(p docker.PullProgress) {
+		switch p.Status {
+		case "Downloading":
+			layers[p.Layer] = p
+		case "Download complete":
+			layer := layers[p.Layer]
+			layer.Current = layer.Total
+			layers[p.Layer] = layer
+		default:
+			return
+		}
+		status := ImageStatus{Image: image.String(), State: imagePulling}
+		for _, layer := range layers {
+			status.Downloaded += layer.Current
+			status.Total += layer.Total
+		}
+		setImageStatus(service, status)
+	}
+
+	opts := docker.PullOptions{Registry: image.Registry(), Progress: progress}
+	if auth, ok := registryAuth[image.Registry()]; ok {
+		opts.Auth = &auth
+	}
+	if err := containerRuntime.ImagePull(ctx, image.Ref(), opts); err != nil {
+		return fail(err)
+	}
+	if image.Digest != "" {
+		if err := containerRuntime.ImageTag(ctx, image.Ref(), image.Repository, image.Tag); err != nil {
+			return fail(err)
+		}
+	}
+
+	zap.L().Info("Pulled image", zap.String("service", service), zap.String("image", image.String()))
+	setImageStatus(service, ImageStatus{Image: image.String(), State: imagePresent})
+	return image.Ref(), nil
+}
+
+// localImage returns the local reference of an image, if it is present.
+// Tarballs keep no digests, so for a pinned image that was imported its tag
+// is accepted instead.
+func localImage(ctx context.Context, image assets.Image) (string, bool, error) {
+	_, err := containerRuntime.ImageInspect(ctx, image.Ref())
+	if err == nil {
+		return image.Ref(), true, nil
+	}
+	if !docker.IsNotFound(err) {
+		return "", false, err
+	}
+	if image.Digest == "" {
+		return "", false, nil
+	}
+
+	info, err := containerRuntime.ImageInspect(ctx, image.TagRef())
+	switch {
+	case docker.IsNotFound(err):
+		return "", false, nil
+	case err != nil:
+		return "", false, err
+	case len(info.RepoDigests) > 0:
+		// pulled by tag from a registry where the tag has moved
+		return "", false, nil
+	}
+	zap.L().Warn("Using imported image, its digest can't be verified", zap.String("image", image.TagRef()))
+	return image.TagRef(), true, nil
+}
+
+// ExportImages writes the images of all services to a tarball for hosts
+// without registry access, pulling them first if needed
+func ExportImages(path string) error {
+	var refs []string
+	for _, service := range assets.Services() {
+		if _, err := provideImage(service); err != nil {
+			return err
+		}
+		image, _ := assets.ServiceImage(service)
+		refs = append(refs, image.TagRef())
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerPullTimeout)
+	defer cancel()
+
+	if err := containerRuntime.ImageSave(ctx, refs, f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// ImportImages loads a tarball written by ExportImages and returns the
+// service images that are still missing
+func ImportImages(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerPullTimeout)
+	defer cancel()
+
+	if err := containerRuntime.ImageLoad(ctx, f); err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, service := range assets.Services() {
+		image, _ := assets.ServiceImage(service)
+		if _, found, err := localImage(ctx, image); err != nil || !found {
+			missing = append(missing, image.String())
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/assets"
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/docker"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// setImageOptions applies image options for a test
+func setImageOptions(t *testing.T, opts assets.ImageOptions) {
+	t.Helper()
+	if err := assets.SetImageOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { assets.SetImageOptions(assets.ImageOptions{}) })
+}
+
+func TestProvideImagePinned(t *testing.T) {
+	fake := useFakeRuntime(t)
+	setImageOptions(t, assets.ImageOptions{Overrides: map[string]string{
+		"nfg-syslog": "linuxserver/syslog-ng:4.10.2@" + testDigest,
+	}})
+
+	ref, err := provideImage("nfg-syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref != "linuxserver/syslog-ng@"+testDigest {
+		t.Errorf("got ref %s, want the digest", ref)
+	}
+	// tagged after the pull, so exports keep the name
+	info, err := fake.ImageInspect(context.Background(), "linuxserver/syslog-ng:4.10.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(info.RepoDigests, ref) {
+		t.Errorf("tag points to another image: %+v", info)
+	}
+
+	status := ImageStatuses()["nfg-syslog"]
+	if status.State != imagePresent || status.Image != "linuxserver/syslog-ng:4.10.2@"+testDigest {
+		t.Errorf("got status %+v", status)
+	}
+}
+
+func TestProvideImageMovedTag(t *testing.T) {
+	fake := useFakeRuntime(t)
+	setImageOptions(t, assets.ImageOptions{Overrides: map[string]string{
+		"nfg-syslog": "linuxserver/syslog-ng:4.10.2@" + testDigest,
+	}})
+
+	// pulled by tag from a registry where the tag has moved since
+	other := "linuxserver/syslog-ng@sha256:" + strings.Repeat("f", 64)
+	fake.AddImage(other)
+	if err := fake.ImageTag(context.Background(), other, "linuxserver/syslog-ng", "4.10.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := provideImage("nfg-syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref != "linuxserver/syslog-ng@"+testDigest {
+		t.Errorf("got ref %s, want the pinned digest", ref)
+	}
+}
+
+func TestExportImportImages(t *testing.T) {
+	useFakeRuntime(t)
+	setImageOptions(t, assets.ImageOptions{Overrides: map[string]string{
+		"nfg-syslog": "linuxserver/syslog-ng:4.10.2@" + testDigest,
+	}})
+
+	tarball := filepath.Join(t.TempDir(), "images.tar")
+	if err := ExportImages(tarball); err != nil {
+		t.Fatal(err)
+	}
+
+	// an air-gapped host only has what was imported, without digests
+	offline := useFakeRuntime(t)
+	missing, err := ImportImages(tarball)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) > 0 {
+		t.Errorf("images missing after import: %v", missing)
+	}
+
+	ref, err := provideImage("nfg-syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref != "linuxserver/syslog-ng:4.10.2" {
+		t.Errorf("got ref %s, want the imported tag", ref)
+	}
+	if _, err := offline.ImageInspect(context.Background(), "linuxserver/syslog-ng@"+testDigest); err == nil {
+		t.Error("pinned image was pulled although the imported one is present")
+	}
+}
+
+// pullRecorder records the options of image pulls
+type pullRecorder struct {
+	*docker.Fake
+	pulls map[string]docker.PullOptions
+}
+
+func (r *pullRecorder) ImagePull(ctx context.Context, ref string, opts docker.PullOptions) error {
+	r.pulls[ref] = opts
+	return r.Fake.ImagePull(ctx, ref, opts)
+}
+
+func TestProvideImageRegistryAuth(t *testing.T) {
+	rt := &pullRecorder{Fake: useFakeRuntime(t), pulls: map[string]docker.PullOptions{}}
+	SetContainerRuntime(rt)
+
+	t.Setenv("IMAGE_REGISTRY_MIRROR", "mirror.example.com:5000")
+	t.Setenv("IMAGE_NFG_LOGSTASH", "registry.example.com/logstash:8.19.2")
+	t.Setenv("IMAGE_REGISTRY_AUTH", `{"mirror.example.com:5000":{"username":"nfg","password":"s3cret"}}`)
+	if err := loadImageSettings(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		assets.SetImageOptions(assets.ImageOptions{})
+		registryAuth = nil
+	})
+
+	if report := ImageReport(); report != "nfg-logstash=registry.example.com/logstash:8.19.2,nfg-syslog=mirror.example.com:5000/linuxserver/syslog-ng:4.10.2" {
+		t.Errorf("got image report %s", report)
+	}
+
+	for _, service := range assets.Services() {
+		if _, err := provideImage(service); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	syslog, ok := rt.pulls["mirror.example.com:5000/linuxserver/syslog-ng:4.10.2"]
+	if !ok {
+		t.Fatalf("syslog image was not pulled from the mirror: %v", rt.pulls)
+	}
+	if syslog.Registry != "mirror.example.com:5000" || syslog.Auth == nil || syslog.Auth.Username != "nfg" || syslog.Auth.Password != "s3cret" {
+		t.Errorf("got pull options %+v", syslog)
+	}
+
+	logstash, ok := rt.pulls["registry.example.com/logstash:8.19.2"]
+	if !ok {
+		t.Fatalf("logstash override was not pulled: %v", rt.pulls)
+	}
+	if logstash.Registry != "registry.example.com" || logstash.Auth != nil {
+		t.Errorf("got pull options %+v, want an anonymous pull", logstash)
+	}
+}
+
+func TestLoadImageSettingsInvalid(t *testing.T) {
+	tests := map[string]map[string]string{
+		"auth":     {"IMAGE_REGISTRY_AUTH": `{"docker.io":"nfg:s3cret"}`},
+		"override": {"IMAGE_NFG_SYSLOG": "linuxserver/syslog-ng"},
+		"mirror":   {"IMAGE_REGISTRY_MIRROR": "mirror.example.com/docker"},
+	}
+	for name, env := range tests {
+		t.Run(name, func(t *testing.T) {
+			for key, value := range env {
+				t.Setenv(key, value)
+			}
+			t.Cleanup(func() { assets.SetImageOptions(assets.ImageOptions{}) })
+			if err := loadImageSettings(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -1,19 +1,36 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/assets"
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/logstash"
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/models"
 	"go.uber.org/zap"
 )
 
 func RestartLogstash(c *Config) {
+	if c.NativePoller() {
+		zap.L().Info("Restart native Elasticsearch poller")
+		if err := restartNativePoller(c); err != nil {
+			zap.L().Error("Failed to start native Elasticsearch poller", zap.Error(err))
+		}
+		return
+	}
+
 	zap.L().Info("Restart container nfg-logstash")
 
 	// Check if container "nfg-logstash" exists
 	if containerExists("nfg-logstash") {
 		zap.L().Info("Container nfg-logstash exists, attempting removal")
-		err := forceRemoveContainer("nfg-logstash")
+		err := gracefulRemoveContainer("nfg-logstash", logstashStopTimeout)
 		if err != nil {
 			zap.L().Warn("Failed to stop/remove container nfg-logstash", zap.Error(err))
 		}
@@ -22,12 +39,11 @@ func RestartLogstash(c *Config) {
 		zap.L().Info("No existing container nfg-logstash found")
 	}
 
-	// Check if the "tpotce_nginx_local" network exists
-	if !networkExists("tpotce_nginx_local") {
-		zap.L().Warn("Docker network tpotce_nginx_local does not exist; skipping container restart")
+	if err := checkLogstashNetwork(c); err != nil {
+		zap.L().Warn("Skipping container restart", zap.Error(err))
 		return
 	}
-	zap.L().Info("Docker network tpotce_nginx_local is present")
+	zap.L().Info("Docker network for nfg-logstash is ready", zap.String("network", c.LogstashNetwork))
 
 	if err := generateLogstashConfig(c); err != nil {
 		zap.L().Error("Failed to generate logstash config", zap.Error(err))
@@ -45,6 +61,20 @@ func RestartLogstash(c *Config) {
 }
 
 func HandleLogstashChange(c *Config) {
+	// the native poller runs in-process, there is no container to manage
+	if c.NativePoller() {
+		if c.LogstashEnabled {
+			zap.L().Info("Logstash enabled, starting native Elasticsearch poller")
+			if err := restartNativePoller(c); err != nil {
+				zap.L().Error("Failed to start native Elasticsearch poller", zap.Error(err))
+			}
+		} else {
+			zap.L().Info("Logstash disabled, stopping native Elasticsearch poller")
+			StopNativePoller()
+		}
+		return
+	}
+
 	if c.LogstashEnabled {
 		zap.L().Info("Logstash enabled, generating config and starting container",
 			zap.String("aggregatorName", c.AggregatorName),
@@ -53,7 +83,7 @@ func HandleLogstashChange(c *Config) {
 		// Check if container "nfg-logstash" exists
 		if containerExists("nfg-logstash") {
 			zap.L().Info("Container nfg-logstash exists, attempting removal")
-			err := forceRemoveContainer("nfg-logstash")
+			err := gracefulRemoveContainer("nfg-logstash", logstashStopTimeout)
 			if err != nil {
 				zap.L().Warn("Failed to stop/remove container nfg-logstash", zap.Error(err))
 			}
@@ -62,12 +92,11 @@ func HandleLogstashChange(c *Config) {
 			zap.L().Info("No existing container nfg-logstash found")
 		}
 
-		// Check if the "tpotce_nginx_local" network exists
-		if !networkExists("tpotce_nginx_local") {
-			zap.L().Warn("Docker network tpotce_nginx_local does not exist; wont start nfg-logstash container without this network")
+		if err := checkLogstashNetwork(c); err != nil {
+			zap.L().Warn("Wont start nfg-logstash container without its network", zap.Error(err))
 			return
 		}
-		zap.L().Info("Docker network tpotce_nginx_local is present")
+		zap.L().Info("Docker network for nfg-logstash is ready", zap.String("network", c.LogstashNetwork))
 
 		if err := generateLogstashConfig(c); err != nil {
 			zap.L().Error("Failed to generate logstash config", zap.Error(err))
@@ -94,57 +123,370 @@ func HandleLogstashChange(c *Config) {
 	}
 }
 
+// ReloadLogstashPipeline regenerates the pipeline and rewrites it in place,
+// the running logstash picks it up through config.reload.automatic. If no
+// logstash is running, or the native poller is used, it is restarted instead.
+func ReloadLogstashPipeline(c *Config) {
+	if c.NativePoller() || !ContainerRunning("nfg-logstash") {
+		HandleLogstashChange(c)
+		return
+	}
+
+	if err := generateLogstashConfig(c); err != nil {
+		zap.L().Error("Failed to generate logstash config, keeping the running pipeline", zap.Error(err))
+		return
+	}
+	if _, err := assets.UpdateConfigFile(assets.LogstashConfig, c.LogstashConfig); err != nil {
+		zap.L().Warn("Failed to update logstash pipeline in place, restarting container", zap.Error(err))
+		HandleLogstashChange(c)
+		return
+	}
+	zap.L().Info("Updated logstash pipeline, logstash reloads it automatically")
+}
+
 func generateLogstashConfig(c *Config) error {
 	zap.L().Info("Generating logstash config",
 		zap.String("path", "./logstash/logstash.conf"),
 		zap.String("aggregatorName", c.AggregatorName),
 	)
 
-	inputBlocks := []string{}
+	settings := c.effectiveLogstashSettings()
+	if settings.QueueMaxMB < logstashMinQueueMB {
+		return fmt.Errorf("logstash queue size %dMB is below the minimum of %dMB", settings.QueueMaxMB, logstashMinQueueMB)
+	}
 
-	for _, target := range c.ElasticsearchTargets {
-		url := target.URL
-		user := target.User
-		pass := target.Password
+	var inputs []logstash.Block
 
-		if url == "" || user == "" {
+	targets := c.EffectiveElasticsearchTargets()
+	passed := preflightElasticsearchTargets(c, targets)
+	for i, target := range targets {
+		if !passed[i] {
 			continue
 		}
-
-		block := fmt.Sprintf(`input {
-	elasticsearch {
-		hosts => "%s"
-		user => "%s"
-		password => "%s"
-		index => "logstash-*"
-		query => '{ "query": { "range": { "@timestamp": { "gt": "now-2s" } } } }'
-		schedule => "*/2 * * * * *"
-		docinfo => true
+		input, err := renderLogstashInput(c, i, target)
+		if err != nil {
+			zap.L().Warn("Skipping invalid Elasticsearch target", zap.String("url", target.URL), zap.Error(err))
+			continue
+		}
+		inputs = append(inputs, input)
+		if target.Flavor == flavorOpenSearch {
+			zap.L().Warn("OpenSearch target is polled over a sliding time window, documents indexed late or while Logstash is down are missed and others may be forwarded twice. Use LOGSTASH_MODE=native to resume from a checkpoint",
+				zap.String("url", target.URL),
+			)
+		}
 	}
-}`, url, user, pass)
 
-		inputBlocks = append(inputBlocks, block)
+	if len(inputs) == 0 {
+		return fmt.Errorf("no valid ELK targets found...")
 	}
 
-	if len(inputBlocks) == 0 {
-		return fmt.Errorf("no valid ELK targets found...")
+	output := logstash.Plugin{
+		Name: "http",
+		Settings: []logstash.Setting{
+			{Name: "url", Value: logstash.String(c.NfgThreatCollectorUrl + "/t-pot")},
+			{Name: "http_method", Value: logstash.String("post")},
+			{Name: "format", Value: logstash.String("json")},
+			{Name: "headers", Value: logstash.Hash{
+				{Key: "X-AUTH_KEY", Value: logstash.Env(authKeyEnv)},
+				{Key: "X-AGGREGATOR_NAME", Value: logstash.String(c.AggregatorName)},
+			}},
+			// Retryable responses and connection errors are retried until the
+			// collector accepts the batch, events stay in the persistent queue
+			// meanwhile. Requests are POSTs, so retries must be allowed for
+			// non-idempotent methods.
+			{Name: "retry_failed", Value: logstash.Bool(true)},
+			{Name: "retry_non_idempotent", Value: logstash.Bool(true)},
+			{Name: "automatic_retries", Value: logstash.Int(settings.OutputRetries)},
+			{Name: "pool_max", Value: logstash.Int(settings.OutputPoolMax)},
+			{Name: "pool_max_per_route", Value: logstash.Int(settings.OutputPoolMax)},
+			{Name: "request_timeout", Value: logstash.Int(settings.OutputTimeoutSeconds)},
+		},
 	}
 
-	outputBlock := fmt.Sprintf(`
-output {
-	http {
-		url => "%s/t-pot"
-		http_method => "post"
-		format => "json"
-		headers => {
-			"X-AUTH_KEY" => "%s"
-			"X-AGGREGATOR_NAME" => "%s"
-		}
+	sections := []logstash.Section{{Type: "input", Blocks: inputs}}
+	filters, err := logstashFilterBlocks(c.LogstashFilter)
+	if err != nil {
+		return fmt.Errorf("invalid logstash filter: %w", err)
 	}
-}`, c.NfgThreatCollectorUrl, c.AuthSecret, c.AggregatorName)
+	if len(filters) > 0 {
+		sections = append(sections, logstash.Section{Type: "filter", Blocks: filters})
+	}
+	sections = append(sections, logstash.Section{Type: "output", Blocks: []logstash.Block{output}})
 
-	fullConf := strings.Join(inputBlocks, "\n\n") + "\n\n" + outputBlock
+	conf := logstash.Config{Sections: sections}
+	fullConf, err := conf.Render()
+	if err != nil {
+		return err
+	}
 	c.LogstashConfig = fullConf
 
 	return nil
 }
+
+// logstashStopTimeout is how long logstash may take to drain in-flight
+// batches into its persistent queue before the container is killed
+const logstashStopTimeout = 60 * time.Second
+
+// logstashMinQueueMB is the persistent queue page size, queue.max_bytes
+// can't be smaller
+const logstashMinQueueMB = 64
+
+var defaultLogstashSettings = models.LogstashSettings{
+	PipelineWorkers:      2,
+	BatchSize:            125,
+	QueueMaxMB:           1024,
+	DLQMaxMB:             1024,
+	OutputRetries:        3,
+	OutputPoolMax:        50,
+	OutputTimeoutSeconds: 60,
+}
+
+// effectiveLogstashSettings merges the local overrides, the settings from the
+// controller and the aggregator defaults, in that order of precedence.
+func (c *Config) effectiveLogstashSettings() models.LogstashSettings {
+	pick := func(override, remote, fallback int) int {
+		if override != 0 {
+			return override
+		}
+		if remote > 0 {
+			return remote
+		}
+		return fallback
+	}
+	o, r, d := c.LogstashSettingOverrides, c.LogstashSettings, defaultLogstashSettings
+	return models.LogstashSettings{
+		PipelineWorkers:      pick(o.PipelineWorkers, r.PipelineWorkers, d.PipelineWorkers),
+		BatchSize:            pick(o.BatchSize, r.BatchSize, d.BatchSize),
+		QueueMaxMB:           pick(o.QueueMaxMB, r.QueueMaxMB, d.QueueMaxMB),
+		DLQMaxMB:             pick(o.DLQMaxMB, r.DLQMaxMB, d.DLQMaxMB),
+		OutputRetries:        pick(o.OutputRetries, r.OutputRetries, d.OutputRetries),
+		OutputPoolMax:        pick(o.OutputPoolMax, r.OutputPoolMax, d.OutputPoolMax),
+		OutputTimeoutSeconds: pick(o.OutputTimeoutSeconds, r.OutputTimeoutSeconds, d.OutputTimeoutSeconds),
+	}
+}
+
+// logstashYmlValues fills the placeholders of the embedded logstash.yml
+func logstashYmlValues(s models.LogstashSettings) map[string]string {
+	return map[string]string{
+		"PIPELINE_WORKERS":    strconv.Itoa(s.PipelineWorkers),
+		"PIPELINE_BATCH_SIZE": strconv.Itoa(s.BatchSize),
+		"QUEUE_MAX_MB":        strconv.Itoa(s.QueueMaxMB),
+		"DLQ_MAX_MB":          strconv.Itoa(s.DLQMaxMB),
+	}
+}
+
+// Elasticsearch target flavors
+const (
+	flavorElasticsearch = "elasticsearch"
+	flavorOpenSearch    = "opensearch"
+)
+
+// renderLogstashInput builds the input plugin polling one target. Secrets
+// are referenced through the container environment (see logstashSecrets).
+func renderLogstashInput(c *Config, i int, target ElasticsearchTarget) (logstash.Plugin, error) {
+	if target.URL == "" {
+		return logstash.Plugin{}, fmt.Errorf("missing url")
+	}
+	if target.User == "" && target.APIKey == "" {
+		return logstash.Plugin{}, fmt.Errorf("missing user or api_key")
+	}
+
+	flavor := target.Flavor
+	if flavor == "" {
+		flavor = flavorElasticsearch
+	}
+	if flavor != flavorElasticsearch && flavor != flavorOpenSearch {
+		return logstash.Plugin{}, fmt.Errorf("unsupported flavor %q", target.Flavor)
+	}
+	if target.VerificationMode != "" && target.VerificationMode != "full" && target.VerificationMode != "none" {
+		return logstash.Plugin{}, fmt.Errorf("unsupported verification_mode %q", target.VerificationMode)
+	}
+	if flavor == flavorOpenSearch && (target.APIKey != "" || target.CATrustedFingerprint != "") {
+		return logstash.Plugin{}, fmt.Errorf("api_key and ca_trusted_fingerprint are not supported for OpenSearch")
+	}
+
+	index := esTargetIndex(c, target)
+
+	var opts []logstash.Setting
+	set := func(name string, value logstash.Value) {
+		opts = append(opts, logstash.Setting{Name: name, Value: value})
+	}
+
+	set("hosts", logstash.String(target.URL))
+	if target.User != "" {
+		set("user", logstash.String(target.User))
+		// the password is resolved by logstash from the container environment
+		if target.Password != "" {
+			set("password", logstash.Env(esPasswordEnv(i)))
+		} else {
+			set("password", logstash.String(""))
+		}
+	}
+	set("index", logstash.String(index))
+
+	switch flavor {
+	case flavorElasticsearch:
+		if target.APIKey != "" {
+			set("api_key", logstash.Env(esAPIKeyEnv(i)))
+		}
+		if target.CAFile != "" {
+			set("ssl_certificate_authorities", logstash.Array{logstash.String(logstashCAPath(target.CAFile))})
+		}
+		if target.CATrustedFingerprint != "" {
+			set("ca_trusted_fingerprint", logstash.String(target.CATrustedFingerprint))
+		}
+		if target.VerificationMode != "" {
+			set("ssl_verification_mode", logstash.String(target.VerificationMode))
+		}
+
+		// Polling resumes from the last seen value of the tracking field,
+		// persisted per target on the nfg-logstash-data volume. :present
+		// lags behind now so documents still being indexed are not skipped.
+		field := c.LogstashTrackingField
+		query, err := json.Marshal(map[string]any{
+			"query": map[string]any{
+				"range": map[string]any{
+					field: map[string]string{"gt": ":last_value", "lte": ":present"},
+				},
+			},
+			"sort": []any{map[string]string{field: "asc"}},
+		})
+		if err != nil {
+			return logstash.Plugin{}, err
+		}
+		set("query", logstash.String(query))
+		set("schedule", logstash.String(c.LogstashSchedule))
+		set("search_api", logstash.String("search_after"))
+		set("size", logstash.Int(c.LogstashPageSize))
+		set("tracking_field", logstash.String("["+field+"]"))
+		set("tracking_field_seed", logstash.String(logstashSeed))
+		set("last_run_metadata_path", logstash.String(logstashCheckpointPath(target)))
+
+	case flavorOpenSearch:
+		if strings.HasPrefix(target.URL, "https://") || target.CAFile != "" {
+			set("ssl", logstash.Bool(true))
+		}
+		if target.CAFile != "" {
+			set("ca_file", logstash.String(logstashCAPath(target.CAFile)))
+		}
+		if target.VerificationMode == "none" {
+			set("ssl_certificate_verification", logstash.Bool(false))
+		}
+
+		// the opensearch input has no tracking support, poll a sliding
+		// window. Nothing is delivered reliably, which generateLogstashConfig
+		// warns about.
+		set("query", logstash.String(`{ "query": { "range": { "@timestamp": { "gt": "now-2s" } } } }`))
+		set("schedule", logstash.String(c.LogstashSchedule))
+		set("size", logstash.Int(c.LogstashPageSize))
+	}
+	set("docinfo", logstash.Bool(true))
+
+	return logstash.Plugin{Name: flavor, Settings: opts}, nil
+}
+
+// fields every forwarded T-Pot event keeps, regardless of IncludeFields
+var logstashRequiredFields = []string{"@timestamp", "type"}
+
+// logstashFilterBlocks renders the honeypot type and field filters sent by
+// the controller. Events of other honeypot types are dropped, and fields are
+// pruned before they are shipped to the collector.
+func logstashFilterBlocks(f models.LogstashFilter) ([]logstash.Block, error) {
+	var blocks []logstash.Block
+
+	if len(f.HoneypotTypes) > 0 {
+		blocks = append(blocks, logstash.If{
+			Condition: logstash.NotIn{Field: logstash.FieldRef{"type"}, Values: f.HoneypotTypes},
+			Then:      []logstash.Block{logstash.Plugin{Name: "drop"}},
+		})
+	}
+
+	// prune matches field names against regular expressions
+	names := func(fields []string) (logstash.Array, error) {
+		var patterns logstash.Array
+		for _, field := range fields {
+			if field == "" {
+				return nil, fmt.Errorf("empty field name")
+			}
+			patterns = append(patterns, logstash.String("^"+regexp.QuoteMeta(field)+"$"))
+		}
+		return patterns, nil
+	}
+
+	var prune []logstash.Setting
+	if len(f.IncludeFields) > 0 {
+		include, err := names(append(slices.Clone(logstashRequiredFields), f.IncludeFields...))
+		if err != nil {
+			return nil, err
+		}
+		prune = append(prune, logstash.Setting{Name: "whitelist_names", Value: include})
+	}
+	if len(f.ExcludeFields) > 0 {
+		var exclude []string
+		for _, field := range f.ExcludeFields {
+			if slices.Contains(logstashRequiredFields, field) {
+				return nil, fmt.Errorf("field %s can't be excluded", field)
+			}
+			exclude = append(exclude, field)
+		}
+		patterns, err := names(exclude)
+		if err != nil {
+			return nil, err
+		}
+		prune = append(prune, logstash.Setting{Name: "blacklist_names", Value: patterns})
+	}
+	if len(prune) > 0 {
+		blocks = append(blocks, logstash.Plugin{Name: "prune", Settings: prune})
+	}
+
+	return blocks, nil
+}
+
+// usesOpenSearch reports whether any target needs the opensearch input plugin
+func usesOpenSearch(c *Config) bool {
+	for _, target := range c.EffectiveElasticsearchTargets() {
+		if target.Flavor == flavorOpenSearch {
+			return true
+		}
+	}
+	return false
+}
+
+// logstashCAFiles maps the CA files of all targets on the host to their
+// path inside the nfg-logstash container
+func logstashCAFiles(c *Config) map[string]string {
+	files := map[string]string{}
+	for _, target := range c.EffectiveElasticsearchTargets() {
+		if target.CAFile != "" {
+			files[target.CAFile] = logstashCAPath(target.CAFile)
+		}
+	}
+	return files
+}
+
+// logstashCAPath returns where a host CA file is mounted in the nfg-logstash container
+func logstashCAPath(hostPath string) string {
+	sum := sha256.Sum256([]byte(hostPath))
+	return fmt.Sprintf("/usr/share/logstash/config/certs/%x.pem", sum[:8])
+}
+
+// logstashSeed is where polling starts for targets without a checkpoint,
+// matching the previous behaviour of forwarding new documents only
+var logstashSeed = time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+
+// logstashCheckpointPath returns the checkpoint file of a target inside the
+// logstash data volume. It is derived from the target URL and index so
+// reordering ELASTICSEARCH_TARGETS does not mix up checkpoints.
+func logstashCheckpointPath(target ElasticsearchTarget) string {
+	return fmt.Sprintf("/usr/share/logstash/data/checkpoints/%s.last_run", esTargetKey(target))
+}
+
+// esTargetKey identifies a target by its URL and index
+func esTargetKey(target ElasticsearchTarget) string {
+	key := target.URL
+	if target.Index != "" {
+		key += "|" + target.Index
+	}
+	sum := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%x", sum[:8])
+}
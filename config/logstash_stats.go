@@ -0,0 +1,77 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// LogstashStats is the part of the logstash node stats API the aggregator uses
+type LogstashStats struct {
+	Pipelines map[string]LogstashPipelineStats `json:"pipelines"`
+}
+
+type LogstashPipelineStats struct {
+	Events struct {
+		In       int64 `json:"in"`
+		Filtered int64 `json:"filtered"`
+		Out      int64 `json:"out"`
+	} `json:"events"`
+	Plugins struct {
+		Inputs  []LogstashPluginStats `json:"inputs"`
+		Filters []LogstashPluginStats `json:"filters"`
+		Outputs []LogstashPluginStats `json:"outputs"`
+	} `json:"plugins"`
+	Reloads struct {
+		Successes            int64      `json:"successes"`
+		Failures             int64      `json:"failures"`
+		LastSuccessTimestamp *time.Time `json:"last_success_timestamp"`
+		LastFailureTimestamp *time.Time `json:"last_failure_timestamp"`
+		LastError            *struct {
+			Message string `json:"message"`
+		} `json:"last_error"`
+	} `json:"reloads"`
+	Queue struct {
+		Type                string `json:"type"`
+		EventsCount         int64  `json:"events_count"`
+		QueueSizeInBytes    int64  `json:"queue_size_in_bytes"`
+		MaxQueueSizeInBytes int64  `json:"max_queue_size_in_bytes"`
+	} `json:"queue"`
+	DeadLetterQueue struct {
+		QueueSizeInBytes int64 `json:"queue_size_in_bytes"`
+	} `json:"dead_letter_queue"`
+}
+
+type LogstashPluginStats struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Events struct {
+		In  int64 `json:"in"`
+		Out int64 `json:"out"`
+	} `json:"events"`
+	Failures int64 `json:"failures"`
+}
+
+// LogstashNodeStats reads the pipeline stats from the monitoring API of the
+// running nfg-logstash container
+func LogstashNodeStats() (*LogstashStats, error) {
+	output, err := execInContainer("nfg-logstash", "curl", "-sf", "http://localhost:9600/_node/stats/pipelines")
+	if err != nil {
+		return nil, err
+	}
+
+	var stats LogstashStats
+	if err := json.Unmarshal([]byte(output), &stats); err != nil {
+		return nil, fmt.Errorf("failed to parse logstash node stats: %w", err)
+	}
+	return &stats, nil
+}
+
+// ContainerStartedAt returns when a running container was last started
+func ContainerStartedAt(name string) (time.Time, error) {
+	info, err := inspectContainer(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.State.StartedAt, nil
+}
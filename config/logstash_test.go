@@ -0,0 +1,223 @@
+package config
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/assets"
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/docker"
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/models"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestGenerateLogstashConfig(t *testing.T) {
+	fake := useFakeRuntime(t)
+	fake.WaitFunc = func(spec docker.ContainerSpec) (string, int) {
+		return preflightOutput, 0
+	}
+
+	seed := logstashSeed
+	logstashSeed = "2025-06-01T12:00:00.000Z"
+	t.Cleanup(func() { logstashSeed = seed })
+
+	const password = `pa"ss$word\${HOME}`
+	c := &Config{
+		AggregatorName:        "tpot-01",
+		AuthSecret:            `auth"$secret`,
+		NfgThreatCollectorUrl: "https://collector.example.com",
+		LogstashIndexPattern:  "logstash-*",
+		LogstashSchedule:      "*/2 * * * * *",
+		LogstashPageSize:      1000,
+		LogstashTrackingField: "@timestamp",
+		LogstashMode:          logstashModeContainer,
+		ElasticsearchTargets: []ElasticsearchTarget{
+			{URL: "https://es.example.com:9200", User: "elastic", Password: password},
+			{URL: "https://os.example.com:9200", User: "admin", Password: password, Flavor: flavorOpenSearch, Index: "tpot-*"},
+		},
+		LogstashFilter: models.LogstashFilter{
+			HoneypotTypes: []string{"Cowrie"},
+			ExcludeFields: []string{"geoip.ip"},
+		},
+	}
+
+	core, logs := observer.New(zap.WarnLevel)
+	t.Cleanup(zap.ReplaceGlobals(zap.New(core)))
+
+	if err := generateLogstashConfig(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the OpenSearch target can lose documents
+	warnings := logs.FilterMessageSnippet("sliding time window").All()
+	if len(warnings) != 1 || warnings[0].ContextMap()["url"] != "https://os.example.com:9200" {
+		t.Errorf("got %d sliding window warnings, want one for the OpenSearch target", len(warnings))
+	}
+
+	for _, secret := range []string{password, c.AuthSecret, "pa\\\"ss"} {
+		if strings.Contains(c.LogstashConfig, secret) {
+			t.Errorf("config contains the secret %q", secret)
+		}
+	}
+	secrets := logstashSecrets(c)
+	if secrets[esPasswordEnv(0)] != password || secrets[esPasswordEnv(1)] != password || secrets[authKeyEnv] != c.AuthSecret {
+		t.Errorf("secrets are not passed unchanged: %q", secrets)
+	}
+
+	golden := "testdata/logstash.conf.golden"
+	if *update {
+		if err := os.WriteFile(golden, []byte(c.LogstashConfig), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.LogstashConfig != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", c.LogstashConfig, want)
+	}
+}
+
+// sealCredentials encrypts target credentials the way the controller does,
+// see RemoteElasticsearchTarget
+func sealCredentials(t *testing.T, c *Config, url string, creds targetCredentials) string {
+	t.Helper()
+	plain, err := json.Marshal(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte(c.AuthSecret))
+	mac.Write([]byte(credentialsKeyInfo))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	sealed := gcm.Seal(nonce, nonce, plain, []byte(c.AggregatorName+"|"+url))
+	return base64.StdEncoding.EncodeToString(sealed)
+}
+
+// logstashTestSetup runs nfg-logstash on a fake runtime for a controller
+// managed target with the given password, and returns the config and the
+// remote config that deployed it
+func logstashTestSetup(t *testing.T, password string) (*docker.Fake, *Config, RemoteConfig) {
+	t.Helper()
+	fake := useFakeRuntime(t)
+	fake.WaitFunc = func(spec docker.ContainerSpec) (string, int) {
+		return preflightOutput, 0
+	}
+	t.Cleanup(assets.Cleanup)
+
+	c := &Config{
+		AggregatorName:        "tpot-01",
+		AuthSecret:            "secret",
+		NfgThreatCollectorUrl: "https://collector.example.com",
+		LogstashIndexPattern:  "logstash-*",
+		LogstashSchedule:      "*/2 * * * * *",
+		LogstashPageSize:      1000,
+		LogstashTrackingField: "@timestamp",
+		LogstashNetwork:       assets.LogstashNetworkNone,
+		LogstashMode:          logstashModeContainer,
+	}
+	r := RemoteConfig{
+		LogstashEnabled: true,
+		ElasticsearchTargets: []RemoteElasticsearchTarget{{
+			ElasticsearchTarget: ElasticsearchTarget{URL: "https://es.example.com:9200", User: "elastic"},
+			Credentials:         sealCredentials(t, c, "https://es.example.com:9200", targetCredentials{Password: password}),
+		}},
+	}
+	c.LogstashEnabled = true
+	c.RemoteElasticsearchTargets = decryptRemoteTargets(c, r.ElasticsearchTargets)
+	HandleLogstashChange(c)
+	if !ContainerRunning("nfg-logstash") {
+		t.Fatal("nfg-logstash is not running")
+	}
+	return fake, c, r
+}
+
+// logstashContainer returns the ID and spec of the nfg-logstash container
+func logstashContainer(t *testing.T, fake *docker.Fake) (string, docker.ContainerSpec) {
+	t.Helper()
+	info, err := fake.ContainerInspect(context.Background(), "nfg-logstash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, _ := fake.Spec("nfg-logstash")
+	return info.ID, spec
+}
+
+// eventually waits for a change applied by a handler goroutine
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestApplyRemoteConfigReloadsLogstashFilter(t *testing.T) {
+	fake, c, r := logstashTestSetup(t, "first")
+	id, spec := logstashContainer(t, fake)
+
+	var pipeline string
+	for _, bind := range spec.Binds {
+		if strings.HasSuffix(bind, ":/usr/share/logstash/pipeline/logstash.conf") {
+			pipeline, _, _ = strings.Cut(bind, ":")
+		}
+	}
+	if pipeline == "" {
+		t.Fatalf("pipeline is not mounted: %v", spec.Binds)
+	}
+
+	r.LogstashFilter = models.LogstashFilter{HoneypotTypes: []string{"Cowrie"}}
+	c.ApplyRemoteConfig(r)
+
+	eventually(t, "the pipeline is rewritten", func() bool {
+		data, _ := os.ReadFile(pipeline)
+		return strings.Contains(string(data), `if [type] != "Cowrie"`)
+	})
+	if got, _ := logstashContainer(t, fake); got != id {
+		t.Error("container was recreated for a filter change")
+	}
+}
+
+func TestApplyRemoteConfigRecreatesLogstashForSecrets(t *testing.T) {
+	fake, c, r := logstashTestSetup(t, "first")
+	id, spec := logstashContainer(t, fake)
+	if !slices.Contains(spec.Env, esPasswordEnv(0)+"=first") {
+		t.Fatalf("password is not in the environment: %v", spec.Env)
+	}
+
+	r.ElasticsearchTargets[0].Credentials = sealCredentials(t, c, "https://es.example.com:9200", targetCredentials{Password: "second"})
+	c.ApplyRemoteConfig(r)
+
+	eventually(t, "the container is recreated", func() bool {
+		got, spec := logstashContainer(t, fake)
+		return got != id && slices.Contains(spec.Env, esPasswordEnv(0)+"=second")
+	})
+}
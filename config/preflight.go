@@ -0,0 +1,127 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/assets"
+	"go.uber.org/zap"
+)
+
+// checkLogstashNetwork verifies that the docker network nfg-logstash is
+// attached to exists. The host network and no network need no check.
+func checkLogstashNetwork(c *Config) error {
+	switch c.LogstashNetwork {
+	case assets.LogstashNetworkNone, assets.LogstashNetworkHost:
+		return nil
+	}
+	if !networkExists(c.LogstashNetwork) {
+		return fmt.Errorf("docker network %s does not exist", c.LogstashNetwork)
+	}
+	return nil
+}
+
+// checkSyslogPorts verifies that the ports of all syslog instances are not
+// already bound on the host, syslogInstances checks that they are valid and
+// distinct. It must run after any previous nfg-syslog container has been
+// removed, otherwise its own ports are reported as taken.
+func checkSyslogPorts(c *Config) error {
+	instances, err := c.syslogInstances()
+	if err != nil {
+		return err
+	}
+
+	for _, inst := range instances {
+		port := inst.port
+		conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+		if err == nil {
+			conn.Close()
+			continue
+		}
+		if !errors.Is(err, syscall.EACCES) {
+			return fmt.Errorf("%s: udp port %d is already in use on the host: %w", inst.label(), port, err)
+		}
+
+		// Privileged ports can't be bound without root, look for a socket
+		// bound to the port in the kernel's socket tables instead
+		inUse, err := udpPortBound(port)
+		if err != nil {
+			zap.L().Warn("Can't check if a privileged port is free, the syslog container fails to start if it isn't",
+				zap.String("service", inst.label()),
+				zap.Int("port", port),
+				zap.Error(err),
+			)
+			continue
+		}
+		if inUse {
+			return fmt.Errorf("%s: udp port %d is already in use on the host", inst.label(), port)
+		}
+	}
+
+	return nil
+}
+
+// procNetUDP are the UDP socket tables of the host network namespace
+var procNetUDP = []string{"/proc/net/udp", "/proc/net/udp6"}
+
+// udpPortBound reports whether a UDP socket is bound to the port, from the
+// socket tables in /proc/net
+func udpPortBound(port int) (bool, error) {
+	read := 0
+	for _, path := range procNetUDP {
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// no IPv6 support
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		bound, err := socketTableHasPort(f, port)
+		f.Close()
+		if err != nil {
+			return false, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if bound {
+			return true, nil
+		}
+		read++
+	}
+	if read == 0 {
+		return false, fmt.Errorf("no socket table found in /proc/net")
+	}
+	return false, nil
+}
+
+// socketTableHasPort reports whether a /proc/net/{tcp,udp}{,6} table has a
+// socket with the given local port. Local addresses are written as
+// <hex address>:<hex port>.
+func socketTableHasPort(r io.Reader, port int) (bool, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		_, hexPort, found := strings.Cut(fields[1], ":")
+		if !found {
+			return false, fmt.Errorf("unexpected local address %q", fields[1])
+		}
+		local, err := strconv.ParseUint(hexPort, 16, 16)
+		if err != nil {
+			return false, fmt.Errorf("unexpected local address %q", fields[1])
+		}
+		if int(local) == port {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
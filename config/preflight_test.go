@@ -0,0 +1,90 @@
+package config
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const procNetUDPSample = `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  376: 00000000:0202 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 31337 2 0000000000000000 0
+  913: 3500007F:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 18412 2 0000000000000000 0
+`
+
+const procNetUDP6Sample = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  377: 00000000000000000000000000000000:0401 00000000000000000000000000000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 31338 2 0000000000000000 0
+`
+
+func TestSocketTableHasPort(t *testing.T) {
+	tests := []struct {
+		table string
+		port  int
+		want  bool
+	}{
+		{procNetUDPSample, 514, true},
+		{procNetUDPSample, 53, true},
+		{procNetUDPSample, 1025, false},
+		{procNetUDP6Sample, 1025, true},
+		{procNetUDP6Sample, 514, false},
+		{strings.SplitN(procNetUDPSample, "\n", 2)[0], 514, false},
+	}
+	for _, tt := range tests {
+		got, err := socketTableHasPort(strings.NewReader(tt.table), tt.port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("port %d: got %v, want %v", tt.port, got, tt.want)
+		}
+	}
+
+	if _, err := socketTableHasPort(strings.NewReader("header\n 1: 00000000 00000000:0000 07\n"), 514); err == nil {
+		t.Error("expected a malformed table to be rejected")
+	}
+}
+
+func TestUDPPortBound(t *testing.T) {
+	dir := t.TempDir()
+	udp, udp6 := filepath.Join(dir, "udp"), filepath.Join(dir, "udp6")
+	os.WriteFile(udp, []byte(procNetUDPSample), 0o644)
+	os.WriteFile(udp6, []byte(procNetUDP6Sample), 0o644)
+
+	tables := procNetUDP
+	t.Cleanup(func() { procNetUDP = tables })
+
+	procNetUDP = []string{udp, udp6}
+	for port, want := range map[int]bool{514: true, 1025: true, 1026: false} {
+		if got, err := udpPortBound(port); err != nil || got != want {
+			t.Errorf("port %d: got %v, %v", port, got, err)
+		}
+	}
+
+	// hosts without IPv6 have no udp6 table
+	procNetUDP = []string{udp, filepath.Join(dir, "missing")}
+	if got, err := udpPortBound(514); err != nil || !got {
+		t.Errorf("got %v, %v", got, err)
+	}
+
+	procNetUDP = []string{filepath.Join(dir, "missing")}
+	if _, err := udpPortBound(514); err == nil {
+		t.Error("expected an error without any socket table")
+	}
+}
+
+func TestUDPPortBoundHost(t *testing.T) {
+	if _, err := os.Stat("/proc/net/udp"); err != nil {
+		t.Skip("no /proc/net/udp")
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	if bound, err := udpPortBound(port); err != nil || !bound {
+		t.Errorf("port %d: got %v, %v", port, bound, err)
+	}
+}
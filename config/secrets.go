@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Secrets never end up in rendered config files. The files reference
+// environment variables instead, which are passed to the containers through
+// the Docker API.
+const authKeyEnv = "AUTH_KEY"
+
+// esPasswordEnv is the variable holding the password of the i-th Elasticsearch target
+func esPasswordEnv(i int) string {
+	return fmt.Sprintf("ES_PASSWORD_%d", i)
+}
+
+// esAPIKeyEnv is the variable holding the API key of the i-th Elasticsearch target
+func esAPIKeyEnv(i int) string {
+	return fmt.Sprintf("ES_API_KEY_%d", i)
+}
+
+// logstashSecrets returns the variables referenced by the logstash pipeline
+func logstashSecrets(c *Config) map[string]string {
+	secrets := map[string]string{
+		authKeyEnv: c.AuthSecret,
+	}
+	for i, target := range c.EffectiveElasticsearchTargets() {
+		if target.Password != "" {
+			secrets[esPasswordEnv(i)] = target.Password
+		}
+		if target.APIKey != "" {
+			secrets[esAPIKeyEnv(i)] = target.APIKey
+		}
+	}
+	return secrets
+}
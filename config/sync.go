@@ -15,6 +15,12 @@ func Sync(cfg *Config) error {
 	maxRetries := 3
 	backoff := time.Second
 
+	client, err := cfg.HTTPClient()
+	if err != nil {
+		zap.L().Error("Failed to create config sync client", zap.Error(err))
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+
 	zap.L().Info("Starting config sync",
 		zap.String("url", fmt.Sprintf("%s/sync/config", cfg.NfgTfaControllerUrl)),
 	)
@@ -30,9 +36,10 @@ func Sync(cfg *Config) error {
 		}
 		req.Header.Set("X_AUTH_KEY", cfg.AuthSecret)
 		req.Header.Set("X_AGGREGATOR_NAME", cfg.AggregatorName)
+		req.Header.Set("X_AGGREGATOR_IMAGES", ImageReport())
 		zap.L().Debug("request headers", zap.String("X_AUTH_KEY", cfg.AuthSecret), zap.String("X_AGGREGATOR_NAME", cfg.AggregatorName))
 
-		resp, err = http.DefaultClient.Do(req)
+		resp, err = client.Do(req)
 		if err != nil {
 			zap.L().Warn("Failed to fetch aggregator data, retrying",
 				zap.Int("attempt", attempt+1),
@@ -66,15 +73,26 @@ func Sync(cfg *Config) error {
 
 			// update cfg with fetched values
 			cfg.ApplyRemoteConfig(RemoteConfig{
-				SyslogEnabled:   response.Config.SyslogEnabled,
-				LogstashEnabled: response.Config.LogstashEnabled,
-				SyslogServices:  response.Config.SyslogServices,
+				SyslogEnabled:        response.Config.SyslogEnabled,
+				LogstashEnabled:      response.Config.LogstashEnabled,
+				SyslogServices:       response.Config.SyslogServices,
+				SyslogPorts:          response.Config.SyslogPorts,
+				SyslogAllowlists:     response.Config.SyslogAllowlists,
+				SyslogFilters:        response.Config.SyslogFilters,
+				SyslogBodyFormats:    response.Config.SyslogBodyFormats,
+				SyslogInstances:      response.Config.SyslogInstances,
+				SyslogDelivery:       response.Config.SyslogDelivery,
+				LogstashSettings:     response.Config.LogstashSettings,
+				LogstashFilter:       response.Config.LogstashFilter,
+				ElasticsearchTargets: response.Config.ElasticsearchTargets,
 			})
 
 			zap.L().Info("Stored config",
 				zap.Bool("syslogEnabled", cfg.SyslogEnabled),
 				zap.Bool("logstashEnabled", cfg.LogstashEnabled),
 				zap.Any("syslogServices", cfg.SyslogServices),
+				zap.Any("syslogPorts", cfg.EffectiveSyslogPorts()),
+				zap.Int("syslogInstances", len(cfg.SyslogInstances)),
 			)
 			return nil
 		}
@@ -2,67 +2,56 @@ package config
 
 import (
 	"fmt"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/assets"
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/models"
 	"go.uber.org/zap"
 )
 
-func RestartSyslog(c *Config) {
-	zap.L().Info("Restart container nfg-syslog")
-	err := forceRemoveContainer("nfg-syslog")
-	if err != nil {
-		zap.L().Warn("Failed to stop container nfg-syslog:", zap.Error(err))
-	}
+// syslog-ng control socket inside the linuxserver/syslog-ng image
+const syslogCtlSocket = "/config/syslog-ng.ctl"
 
-	if err := generateSyslogConfig(c); err != nil {
-		zap.L().Error("Failed to generate syslog config", zap.Error(err))
-		return
-	}
-	zap.L().Info("Generated syslog config successfully")
+// disk buffer directory on the nfg-syslog-data volume
+const syslogBufferDir = "/config/buffers"
 
-	if err := startContainer("nfg-syslog", c); err != nil {
-		zap.L().Error("Failed to start syslog container", zap.String("container", "nfg-syslog"), zap.Error(err))
-		return
-	}
-	zap.L().Info("Syslog container started", zap.String("container", "nfg-syslog"))
+// where TLS_CA_FILE is mounted in the nfg-syslog container
+const syslogCAFile = "/etc/ssl/nfg/ca.pem"
+
+// hashed system CAs of the linuxserver/syslog-ng image
+const syslogSystemCADir = "/etc/ssl/certs"
+
+// serializes syslog container changes triggered by config updates and the monitor
+var syslogMu sync.Mutex
+
+func RestartSyslog(c *Config) {
+	zap.L().Info("Restart container nfg-syslog")
+	deploySyslog(c)
 }
 
 func HandleSyslogChange(c *Config) {
-	shouldRun := c.SyslogEnabled && AnyEnabled(c.SyslogServices)
+	shouldRun := c.SyslogEnabled && c.AnySyslogSource()
 
 	if shouldRun {
 		zap.L().Info("Syslog enabled with active services, generating config and starting container",
 			zap.String("aggregatorName", c.AggregatorName),
 		)
-
-		// Check if container "nfg-syslog" exists
-		if containerExists("nfg-syslog") {
-			zap.L().Info("Container nfg-syslog exists, attempting removal")
-			err := forceRemoveContainer("nfg-syslog")
-			if err != nil {
-				zap.L().Warn("Failed to stop/remove container nfg-syslog", zap.Error(err))
-			}
-			zap.L().Info("Successfully stopped/removed container nfg-syslog")
-		} else {
-			zap.L().Info("No existing container nfg-syslog found")
-		}
-
-		if err := generateSyslogConfig(c); err != nil {
-			zap.L().Error("Failed to generate syslog config", zap.Error(err))
-			return
-		}
-		zap.L().Info("Generated syslog config successfully")
-
-		if err := startContainer("nfg-syslog", c); err != nil {
-			zap.L().Error("Failed to start syslog container", zap.String("container", "nfg-syslog"), zap.Error(err))
-			return
-		}
-		zap.L().Info("Syslog container started", zap.String("container", "nfg-syslog"))
+		deploySyslog(c)
 
 	} else {
 		zap.L().Info("Syslog disabled, stopping container and cleaning config",
 			zap.String("container", "nfg-syslog"),
 		)
 
+		syslogMu.Lock()
+		defer syslogMu.Unlock()
+
 		if err := stopContainer("nfg-syslog"); err != nil {
 			zap.L().Error("Failed to stop syslog container", zap.String("container", "nfg-syslog"), zap.Error(err))
 		} else {
@@ -71,6 +60,131 @@ func HandleSyslogChange(c *Config) {
 	}
 }
 
+// deploySyslog renders the syslog config, checks it in a throwaway container
+// and only then replaces the running container. If the new container does not
+// become healthy in time, the previously deployed config is restored.
+func deploySyslog(c *Config) {
+	syslogMu.Lock()
+	defer syslogMu.Unlock()
+
+	prevConfig, prevPorts := c.SyslogConfig, c.SyslogPublishedPorts
+
+	if err := generateSyslogConfig(c); err != nil {
+		zap.L().Error("Failed to generate syslog config", zap.Error(err))
+		return
+	}
+	zap.L().Info("Generated syslog config successfully")
+
+	if err := checkSyslogConfigSyntax(c.SyslogConfig); err != nil {
+		zap.L().Error("Syslog config failed syntax check, keeping current container", zap.Error(err))
+		c.SyslogConfig = prevConfig
+		return
+	}
+	zap.L().Info("Syslog config passed syntax check")
+
+	// Published ports are part of the container definition, anything else
+	// can be applied to the running container with a reload
+	newPorts := c.enabledSyslogPorts()
+	if prevConfig != "" && slices.Equal(newPorts, prevPorts) && ContainerRunning("nfg-syslog") {
+		if prevConfig == c.SyslogConfig {
+			zap.L().Info("Syslog config unchanged, keeping running container")
+			return
+		}
+		if err := reloadSyslog(c.SyslogConfig); err != nil {
+			zap.L().Error("Failed to reload syslog config, restoring previous config", zap.Error(err))
+			c.SyslogConfig = prevConfig
+			if _, err := assets.UpdateConfigFile(assets.SyslogConfig, prevConfig); err != nil {
+				zap.L().Error("Failed to restore previous syslog config file", zap.Error(err))
+			}
+			return
+		}
+		zap.L().Info("Syslog config reloaded in place", zap.String("container", "nfg-syslog"))
+		return
+	}
+	c.SyslogPublishedPorts = newPorts
+
+	// Check if container "nfg-syslog" exists
+	if containerExists("nfg-syslog") {
+		zap.L().Info("Container nfg-syslog exists, attempting removal")
+		err := forceRemoveContainer("nfg-syslog")
+		if err != nil {
+			zap.L().Warn("Failed to stop/remove container nfg-syslog", zap.Error(err))
+		}
+		zap.L().Info("Successfully stopped/removed container nfg-syslog")
+	} else {
+		zap.L().Info("No existing container nfg-syslog found")
+	}
+
+	err := checkSyslogPorts(c)
+	if err != nil {
+		err = fmt.Errorf("port preflight failed: %w", err)
+	} else {
+		err = startContainer("nfg-syslog", c)
+	}
+	if err == nil {
+		err = waitForHealthy("nfg-syslog", c.SyslogHealthTimeout)
+	}
+	if err == nil {
+		zap.L().Info("Syslog container started", zap.String("container", "nfg-syslog"))
+		return
+	}
+	zap.L().Error("Syslog container failed to start", zap.String("container", "nfg-syslog"), zap.Error(err))
+
+	if prevConfig == "" || prevConfig == c.SyslogConfig {
+		return
+	}
+
+	zap.L().Warn("Rolling back to previous syslog config", zap.String("container", "nfg-syslog"))
+	c.SyslogConfig, c.SyslogPublishedPorts = prevConfig, prevPorts
+	if err := forceRemoveContainer("nfg-syslog"); err != nil {
+		zap.L().Warn("Failed to stop/remove container nfg-syslog", zap.Error(err))
+	}
+	if err := startContainer("nfg-syslog", c); err != nil {
+		zap.L().Error("Failed to start syslog container with previous config", zap.String("container", "nfg-syslog"), zap.Error(err))
+		return
+	}
+	zap.L().Info("Syslog container restored with previous config", zap.String("container", "nfg-syslog"))
+}
+
+// reloadSyslog rewrites the mounted syslog-ng.conf and tells the running
+// syslog-ng to reload it. syslog-ng keeps the old config if the reload fails.
+func reloadSyslog(conf string) error {
+	if _, err := assets.UpdateConfigFile(assets.SyslogConfig, conf); err != nil {
+		return fmt.Errorf("failed to write syslog config: %w", err)
+	}
+	if _, err := execInContainer("nfg-syslog", "syslog-ng-ctl", "reload", "--control="+syslogCtlSocket); err != nil {
+		return err
+	}
+	if _, err := execInContainer("nfg-syslog", "syslog-ng-ctl", "healthcheck", "--control="+syslogCtlSocket); err != nil {
+		return fmt.Errorf("syslog-ng unhealthy after reload: %w", err)
+	}
+	return nil
+}
+
+// checkSyslogConfigSyntax runs syslog-ng --syntax-only against the config in a
+// throwaway container of the same image, without touching the running one.
+func checkSyslogConfigSyntax(conf string) error {
+	f, err := os.CreateTemp("", "nfg-syslog-check-*.conf")
+	if err != nil {
+		return fmt.Errorf("failed to create temp config: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(conf); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write temp config: %w", err)
+	}
+	f.Close()
+
+	_, err = runThrowawayContainer("nfg-syslog", "none",
+		[]string{f.Name() + ":/tmp/syslog-ng.conf:ro"},
+		[]string{authKeyEnv + "=syntax-check"},
+		"syslog-ng",
+		"--syntax-only", "-f", "/tmp/syslog-ng.conf",
+	)
+	return err
+}
+
 func generateSyslogConfig(c *Config) error {
 	zap.L().Info("Generating syslog config",
 		zap.String("path", "./syslog/syslog-ng.conf"),
@@ -88,139 +202,515 @@ source s_local {
 };
 	`
 
-	if c.SyslogServices.SyslogCiscoFtdEnabled {
-		source += `
-source s_network_firepower {
-	syslog(transport("udp") port(514));
-};
-		`
+	var filter, destination, log string
+
+	delivery := c.effectiveSyslogDelivery()
+	batching, err := renderSyslogBatching(delivery)
+	if err != nil {
+		return err
 	}
 
-	if c.SyslogServices.SyslogCiscoIseEnabled {
-		source += `
-source s_network_ise {
-	syslog(transport("udp") port(1025));
-};
-		`
+	transport, err := renderSyslogTransport(c, delivery)
+	if err != nil {
+		return err
 	}
 
-	if c.SyslogServices.SyslogOpnsenseEnabled {
-		source += `
-source s_network_opnsense {
-	syslog(transport("udp") port(1026));
-};
-		`
+	// Disk buffers live on the nfg-syslog-data volume so queued messages
+	// survive collector outages and container recreation
+	diskBuffer := ""
+	if c.SyslogDiskBufferEnabled {
+		reliable := "no"
+		if c.SyslogDiskBufferReliable {
+			reliable = "yes"
+		}
+		diskBuffer = fmt.Sprintf(`
+		disk-buffer(
+			reliable(%s)
+			capacity-bytes(%dMiB)
+			dir("%s")
+		)`, reliable, c.SyslogDiskBufferSizeMB, syslogBufferDir)
 	}
 
-	if c.SyslogServices.SyslogSuricataEnabled {
-		source += `
-source s_network_suricata {
-    internal();
-    syslog(transport("udp") port(1027));
-};
-		`
+	instances, err := c.syslogInstances()
+	if err != nil {
+		return err
 	}
 
-	// Destinations
-	var destination string
+	for _, inst := range instances {
+		svc := inst.svc
 
-	if c.SyslogServices.SyslogCiscoFtdEnabled {
-		destination += fmt.Sprintf(`
-destination d_http_firepower {
-	http(
-		url("%s/firepower")
-		method("POST")
-		msg_data_in_header(no)
-		headers("X-AUTH_KEY: %s", "X-AGGREGATOR_NAME: %s")
-		body("<$PRI>$YEAR-$MONTH-$DAYT$HOUR:$MIN:$SEC.$MSEC $HOST $PROGRAM: $MSG")
-	);
+		internal := ""
+		if svc.includeInternal {
+			internal = "\n\tinternal();"
+		}
+
+		format := inst.bodyFormat
+		if format == "" {
+			format = bodyFormatText
+		}
+
+		// the JSON body carries the unparsed message, which syslog-ng only keeps on request
+		flags := ""
+		if format == bodyFormatJSON {
+			flags = " flags(store-raw-message)"
+		}
+
+		source += fmt.Sprintf(`
+source s_network_%s {%s
+	syslog(transport("udp") port(%d)%s);
 };
-		`, c.NfgThreatCollectorUrl, c.AuthSecret, c.AggregatorName)
-	}
+		`, inst.id, internal, inst.port, flags)
+
+		var body string
+		switch format {
+		case bodyFormatText:
+			body = fmt.Sprintf(`"%s"`, svc.body)
+			if delivery.Framing == framingJSONArray {
+				// every array element has to be valid JSON, so wrap the line
+				body = fmt.Sprintf(`'$(format-json --scope none message="%s")'`, svc.body)
+			}
+		case bodyFormatJSON:
+			if strings.ContainsAny(c.AggregatorName, syslogTemplateUnsafe) {
+				return fmt.Errorf("%s: the JSON body can't carry the aggregator name %q, it must not contain any of %q", inst.label(), c.AggregatorName, syslogTemplateUnsafe)
+			}
+			body = syslogJSONBody(inst, c.AggregatorName)
+		default:
+			return fmt.Errorf("unsupported body format %q for %s", format, inst.label())
+		}
 
-	if c.SyslogServices.SyslogCiscoIseEnabled {
 		destination += fmt.Sprintf(`
-destination d_http_ise {
+destination d_http_%s {
 	http(
-		url("%s/ise")
+		url("%s%s")
 		method("POST")
 		msg_data_in_header(no)
-		headers("X-AUTH_KEY: %s", "X-AGGREGATOR_NAME: %s")
-		body("<$PRI>$YEAR-$MONTH-$DAYT$HOUR:$MIN:$SEC.$MSEC $HOST $PROGRAM: $MSG")
+		headers(%s)
+		body(%s)%s%s%s
 	);
 };
-		`, c.NfgThreatCollectorUrl, c.AuthSecret, c.AggregatorName)
-	}
+		`, inst.id, c.NfgThreatCollectorUrl, svc.path, syslogHeaders(c, inst, delivery.Framing, format), body, transport, batching, diskBuffer)
 
-	if c.SyslogServices.SyslogOpnsenseEnabled {
-		destination += fmt.Sprintf(`
-destination d_http_opnsense {
-        http(
-                url("%s/opnsense")
-                method("POST")
-                msg_data_in_header(no)
-                headers("X-AUTH_KEY: %s", "X-AGGREGATOR_NAME: %s")
-                body("<$PRI>$YEAR-$MONTH-$DAYT$HOUR:$MIN:$SEC.$MSEC $HOST $PROGRAM: $MSG")
-        );
+		// Dropped messages go to discarding destinations so syslog-ng keeps
+		// a counter for them (see SyslogRejectedCounts and SyslogFilteredCounts)
+		var allowFilter, forwardFilters string
+
+		if len(inst.allowlist) > 0 {
+			expr, err := netmaskExpression(inst.allowlist)
+			if err != nil {
+				return fmt.Errorf("invalid allowlist for %s: %w", inst.label(), err)
+			}
+
+			filter += fmt.Sprintf(`
+filter f_allow_%s {
+	%s;
 };
-		`, c.NfgThreatCollectorUrl, c.AuthSecret, c.AggregatorName)
-	}
 
-	if c.SyslogServices.SyslogSuricataEnabled {
-		destination += fmt.Sprintf(`
-destination d_http_suricata{
-        http(
-                url("%s/suricata")
-                method("POST")
-                msg_data_in_header(no)
-                headers("X-AUTH_KEY: %s", "X-AGGREGATOR_NAME: %s")
-                body("<$PRI>$YEAR-$MONTH-$DAYT$HOUR:$MIN:$SEC.$MSEC $HOST $PROGRAM $MSG")
-        );
+filter f_reject_%s {
+	not filter(f_allow_%s);
 };
-		`, c.NfgThreatCollectorUrl, c.AuthSecret, c.AggregatorName)
-	}
+		`, inst.id, expr, inst.id, inst.id)
 
-	// Log
-	var log string
+			destination += fmt.Sprintf(`
+destination d_rejected_%s {
+	file("/dev/null");
+};
+		`, inst.id)
 
-	if c.SyslogServices.SyslogCiscoFtdEnabled {
-		log += `
+			log += fmt.Sprintf(`
 log {
-	source(s_network_firepower);
-	destination(d_http_firepower);
+	source(s_network_%s);
+	filter(f_reject_%s);
+	destination(d_rejected_%s);
 };
-		`
-	}
+		`, inst.id, inst.id, inst.id)
 
-	if c.SyslogServices.SyslogCiscoIseEnabled {
-		log += `
-log {
-	source(s_network_ise);
-	destination(d_http_ise);
+			allowFilter = fmt.Sprintf("\n\tfilter(f_allow_%s);", inst.id)
+			forwardFilters += allowFilter
+		}
+
+		keep, err := messageFilterExpression(svc, inst.filter)
+		if err != nil {
+			return fmt.Errorf("invalid message filter for %s: %w", inst.label(), err)
+		}
+		if keep != "" {
+			filter += fmt.Sprintf(`
+filter f_keep_%s {
+	%s;
 };
-		`
-	}
 
-	if c.SyslogServices.SyslogOpnsenseEnabled {
-		log += `
+filter f_drop_%s {
+	not filter(f_keep_%s);
+};
+		`, inst.id, keep, inst.id, inst.id)
+
+			destination += fmt.Sprintf(`
+destination d_filtered_%s {
+	file("/dev/null");
+};
+		`, inst.id)
+
+			log += fmt.Sprintf(`
 log {
-    source(s_network_opnsense);
-    destination(d_http_opnsense);
+	source(s_network_%s);%s
+	filter(f_drop_%s);
+	destination(d_filtered_%s);
 };
-		`
-	}
+		`, inst.id, allowFilter, inst.id, inst.id)
 
-	if c.SyslogServices.SyslogSuricataEnabled {
-		log += `
+			forwardFilters += fmt.Sprintf("\n\tfilter(f_keep_%s);", inst.id)
+		}
+
+		log += fmt.Sprintf(`
 log {
-    source(s_network_suricata);
-    destination(d_http_suricata);
+	source(s_network_%s);%s
+	destination(d_http_%s);
 };
-		`
+		`, inst.id, forwardFilters, inst.id)
 	}
 
-	fullConf := headers + "\n\n" + source + "\n\n" + destination + "\n\n" + log
+	fullConf := headers + "\n\n" + source + "\n\n" + filter + "\n\n" + destination + "\n\n" + log
 	c.SyslogConfig = fullConf
 
 	return nil
 }
+
+// syslogHeaders renders the HTTP headers of an instance destination. Named
+// instances identify themselves so the collector can tell them apart. The auth
+// key is a backtick reference to the container environment, not the secret.
+func syslogHeaders(c *Config, inst syslogInstance, framing string, format string) string {
+	headers := []string{
+		"X-AUTH_KEY: `" + authKeyEnv + "`",
+		"X-AGGREGATOR_NAME: " + c.AggregatorName,
+		"X-BATCH-FRAMING: " + framing,
+		"X-BODY-FORMAT: " + format,
+	}
+	if inst.name != "" {
+		headers = append(headers, "X-SOURCE-INSTANCE: "+inst.name)
+	}
+	if inst.tag != "" {
+		headers = append(headers, "X-SOURCE-TAG: "+inst.tag)
+	}
+	for _, k := range slices.Sorted(maps.Keys(inst.headers)) {
+		headers = append(headers, k+": "+inst.headers[k])
+	}
+
+	quoted := make([]string, len(headers))
+	for i, h := range headers {
+		quoted[i] = `"` + syslogStringEscaper.Replace(h) + `"`
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// syslogStringEscaper escapes a value for a double-quoted syslog-ng string
+var syslogStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// characters that can't be passed into the single-quoted syslog-ng template
+// of syslogJSONBody: quotes end a string, $ starts a macro, \ escapes and
+// parentheses end the function call
+const syslogTemplateUnsafe = `"'$\()` + "\r\n"
+
+// syslogJSONBody renders a format-json template carrying the RFC 5424 metadata
+// of a message, so the collector does not have to re-parse the syslog line.
+// $ISODATE includes the timezone offset, the raw message needs store-raw-message.
+// The aggregator name and the tag must not contain syslogTemplateUnsafe,
+// generateSyslogConfig and syslogInstances check them.
+func syslogJSONBody(inst syslogInstance, aggregatorName string) string {
+	return fmt.Sprintf(`'$(format-json --scope none`+
+		` timestamp=$ISODATE received=$R_ISODATE`+
+		` host=$HOST sender_ip=$SOURCEIP`+
+		` facility=$FACILITY severity=$LEVEL priority=int($PRI)`+
+		` program=$PROGRAM pid=$PID msgid=$MSGID`+
+		` message=$MSG raw=$RAWMSG`+
+		` service=%s instance="%s" tag="%s" aggregator="%s"`+
+		` --key .SDATA.* --rekey .SDATA.* --shift 6 --add-prefix structured_data)'`,
+		inst.svc.id, inst.name, inst.tag, aggregatorName)
+}
+
+// renderSyslogTransport renders the TLS, timeout and response-action options
+// of the collector destinations. TLS verification follows the Go clients:
+// SKIP_VERIFY_TLS disables it and TLS_CA_FILE adds a trusted CA.
+func renderSyslogTransport(c *Config, d models.SyslogDelivery) (string, error) {
+	if d.TimeoutSeconds < 1 {
+		return "", fmt.Errorf("invalid syslog delivery timeout: %ds", d.TimeoutSeconds)
+	}
+
+	peerVerify := "yes"
+	if c.InsecureSkipVerifyTLS {
+		peerVerify = "no"
+	}
+	caFile := ""
+	if c.TLSCAFile != "" {
+		// ca-file() replaces the default bundle of curl, ca-dir() keeps the
+		// system CAs trusted next to it
+		caFile = fmt.Sprintf(`
+			ca-dir("%s")
+			ca-file("%s")`, syslogSystemCADir, syslogCAFile)
+	}
+
+	return fmt.Sprintf(`
+		tls(
+			peer-verify(%s)%s
+		)
+		timeout(%d)
+		response-action(%s
+		)`, peerVerify, caFile, d.TimeoutSeconds, syslogResponseActions()), nil
+}
+
+// syslogResponseAction returns what syslog-ng does with a batch the
+// collector answered with an error status. Auth failures disconnect so
+// messages stay queued until the secret is fixed, timeouts, throttling and
+// server errors are retried, and other requests the collector will never
+// accept are dropped.
+func syslogResponseAction(status int) string {
+	switch {
+	case status == 401 || status == 403:
+		return "disconnect"
+	case status == 408 || status == 429 || status >= 500:
+		return "retry"
+	default:
+		return "drop"
+	}
+}
+
+// syslogResponseActions renders the response-action list for every 4xx and
+// 5xx status, so no status falls back to the defaults of the syslog-ng
+// version in the image. 2xx is success by default, 1xx and 3xx are not sent
+// by the collector.
+func syslogResponseActions() string {
+	var b strings.Builder
+	for status := 400; status < 600; status++ {
+		switch {
+		case status%10 == 0:
+			b.WriteString("\n\t\t\t")
+		default:
+			b.WriteString(" ")
+		}
+		fmt.Fprintf(&b, "%d => %s", status, syslogResponseAction(status))
+		if status < 599 {
+			b.WriteString(",")
+		}
+	}
+	return b.String()
+}
+
+// renderSyslogBatching renders the http() options for batching, body framing
+// and compression of the collector destinations.
+func renderSyslogBatching(d models.SyslogDelivery) (string, error) {
+	if d.BatchLines < 1 || d.BatchBytes < 1 || d.BatchTimeoutMs < 1 {
+		return "", fmt.Errorf("invalid syslog batch settings: %+v", d)
+	}
+
+	opts := fmt.Sprintf(`
+		batch-lines(%d)
+		batch-bytes(%d)
+		batch-timeout(%d)`, d.BatchLines, d.BatchBytes, d.BatchTimeoutMs)
+
+	switch d.Framing {
+	case framingNewline:
+		opts += `
+		delimiter("\n")`
+	case framingJSONArray:
+		opts += `
+		body-prefix("[")
+		delimiter(",")
+		body-suffix("]")`
+	default:
+		return "", fmt.Errorf("unsupported syslog batch framing %q", d.Framing)
+	}
+
+	switch d.Compression {
+	case "":
+	case "gzip":
+		opts += `
+		content-compression("gzip")`
+	default:
+		return "", fmt.Errorf("unsupported syslog compression %q", d.Compression)
+	}
+
+	return opts, nil
+}
+
+// netmaskExpression renders a list of CIDRs or plain IPs as a syslog-ng
+// filter expression matching any of them.
+func netmaskExpression(cidrs []string) (string, error) {
+	var terms []string
+	for _, entry := range cidrs {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return "", fmt.Errorf("%q is not an IP address or CIDR", entry)
+			}
+			if ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+
+		ip, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return "", fmt.Errorf("%q is not an IP address or CIDR", entry)
+		}
+		if ip.To4() != nil {
+			terms = append(terms, fmt.Sprintf(`netmask("%s")`, network.String()))
+		} else {
+			terms = append(terms, fmt.Sprintf(`netmask6("%s")`, network.String()))
+		}
+	}
+	return strings.Join(terms, " or "), nil
+}
+
+// syslogStats runs syslog-ng-ctl stats in the nfg-syslog container and sums
+// the counters of the given type for every stats id starting with prefix,
+// keyed by the remainder of the id (the service id).
+func syslogStats(prefix string, counterType string) (map[string]int64, error) {
+	output, err := execInContainer("nfg-syslog", "syslog-ng-ctl", "stats", "--control="+syslogCtlSocket)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int64{}
+	for _, line := range strings.Split(output, "\n") {
+		// SourceName;SourceId;SourceInstance;State;Type;Number
+		fields := strings.Split(strings.TrimSpace(line), ";")
+		if len(fields) != 6 || fields[4] != counterType {
+			continue
+		}
+		id, ok := strings.CutPrefix(fields[1], prefix)
+		if !ok {
+			continue
+		}
+		id, _, _ = strings.Cut(id, "#")
+		n, err := strconv.ParseInt(fields[5], 10, 64)
+		if err != nil {
+			continue
+		}
+		counts[id] += n
+	}
+	return counts, nil
+}
+
+// SyslogRejectedCounts returns the number of messages dropped per service
+// because the sender was not on the allowlist. Counters reset whenever the
+// nfg-syslog container is recreated.
+func SyslogRejectedCounts() (map[string]int64, error) {
+	return syslogStats("d_rejected_", "processed")
+}
+
+// SyslogFilteredCounts returns the number of messages dropped per service by
+// the message filters from the controller.
+func SyslogFilteredCounts() (map[string]int64, error) {
+	return syslogStats("d_filtered_", "processed")
+}
+
+// SyslogQueuedCounts returns the number of messages waiting in the queue
+// (including the disk buffer) of each collector destination.
+func SyslogQueuedCounts() (map[string]int64, error) {
+	return syslogStats("d_http_", "queued")
+}
+
+// DiskBufferUsage is the fill level of the disk buffer of a destination
+type DiskBufferUsage struct {
+	UsedBytes     int64 `json:"usedBytes"`
+	CapacityBytes int64 `json:"capacityBytes"`
+}
+
+// SyslogDiskBufferUsage returns the disk buffer fill level of each collector
+// destination, keyed by service id like SyslogQueuedCounts. It reads the
+// disk queue metrics of syslog-ng, destinations without them are left out.
+// The capacity falls back to the configured size.
+func SyslogDiskBufferUsage(c *Config) (map[string]DiskBufferUsage, error) {
+	if !c.SyslogDiskBufferEnabled {
+		return nil, nil
+	}
+	output, err := execInContainer("nfg-syslog", "syslog-ng-ctl", "stats", "prometheus", "--control="+syslogCtlSocket)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := map[string]DiskBufferUsage{}
+	capacities := map[string]int64{}
+	for _, line := range strings.Split(output, "\n") {
+		name, labels, value, ok := parsePrometheusSample(line)
+		if !ok {
+			continue
+		}
+		id, found := strings.CutPrefix(labels["driver_id"], "d_http_")
+		if !found {
+			continue
+		}
+		id, _, _ = strings.Cut(id, "#")
+
+		switch name {
+		case "syslogng_disk_queue_disk_usage_bytes":
+			u := usage[id]
+			u.UsedBytes += value
+			usage[id] = u
+		case "syslogng_disk_queue_capacity_bytes":
+			capacities[id] += value
+		}
+	}
+
+	for id, u := range usage {
+		u.CapacityBytes = capacities[id]
+		if u.CapacityBytes == 0 {
+			u.CapacityBytes = int64(c.SyslogDiskBufferSizeMB) * 1024 * 1024
+		}
+		usage[id] = u
+	}
+	return usage, nil
+}
+
+// parsePrometheusSample parses a sample line of the Prometheus text format,
+// e.g. name{label="value"} 42. Comments and malformed lines are skipped.
+func parsePrometheusSample(line string) (string, map[string]string, int64, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, 0, false
+	}
+
+	i := strings.LastIndexByte(line, ' ')
+	if i < 0 {
+		return "", nil, 0, false
+	}
+	value, err := strconv.ParseFloat(line[i+1:], 64)
+	if err != nil {
+		return "", nil, 0, false
+	}
+	series := line[:i]
+
+	labels := map[string]string{}
+	name, rest, found := strings.Cut(series, "{")
+	if found {
+		rest, ok := strings.CutSuffix(rest, "}")
+		if !ok {
+			return "", nil, 0, false
+		}
+		for rest != "" {
+			key, after, ok := strings.Cut(rest, `="`)
+			if !ok {
+				return "", nil, 0, false
+			}
+			// label values escape backslashes, quotes and newlines
+			var b strings.Builder
+			j := 0
+			for ; j < len(after) && after[j] != '"'; j++ {
+				if after[j] == '\\' && j+1 < len(after) {
+					j++
+					if after[j] == 'n' {
+						b.WriteByte('\n')
+						continue
+					}
+				}
+				b.WriteByte(after[j])
+			}
+			if j == len(after) {
+				return "", nil, 0, false
+			}
+			labels[strings.TrimSpace(key)] = b.String()
+			rest = strings.TrimPrefix(after[j+1:], ",")
+		}
+	}
+	return name, labels, int64(value), true
+}
@@ -0,0 +1,319 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/models"
+)
+
+const syslogBody = "<$PRI>$YEAR-$MONTH-$DAYT$HOUR:$MIN:$SEC.$MSEC $HOST $PROGRAM: $MSG"
+
+// Body formats accepted by the collector
+const (
+	bodyFormatText = "text"
+	bodyFormatJSON = "json"
+)
+
+// Batch framings accepted by the collector
+const (
+	framingNewline   = "newline"
+	framingJSONArray = "json-array"
+)
+
+var defaultSyslogDelivery = models.SyslogDelivery{
+	BatchLines:     100,
+	BatchBytes:     512 * 1024,
+	BatchTimeoutMs: 1000,
+	Framing:        framingNewline,
+	TimeoutSeconds: 10,
+}
+
+// syslogServiceDef describes one syslog source the aggregator can relay.
+// The id is used for the syslog-ng object names (s_network_<id>, d_http_<id>).
+type syslogServiceDef struct {
+	id              string
+	name            string
+	path            string
+	body            string
+	defaultPort     int
+	includeInternal bool
+	enabled         func(models.SyslogServices) bool
+	port            func(models.SyslogPorts) int
+	setPort         func(*models.SyslogPorts, int)
+	allowlist       func(models.SyslogAllowlists) []string
+	filter          func(models.SyslogFilters) models.SyslogMessageFilter
+	bodyFormat      func(models.SyslogBodyFormats) string
+	// filterRule renders the syslog-ng filter expression for one filter value
+	filterRule func(value string) string
+}
+
+var syslogServiceDefs = []syslogServiceDef{
+	{
+		id:          "firepower",
+		name:        "Cisco FTD",
+		path:        "/firepower",
+		body:        syslogBody,
+		defaultPort: 514,
+		enabled:     func(s models.SyslogServices) bool { return s.SyslogCiscoFtdEnabled },
+		port:        func(p models.SyslogPorts) int { return p.SyslogCiscoFtdPort },
+		setPort:     func(p *models.SyslogPorts, v int) { p.SyslogCiscoFtdPort = v },
+		allowlist:   func(a models.SyslogAllowlists) []string { return a.SyslogCiscoFtdAllowlist },
+		filter:      func(f models.SyslogFilters) models.SyslogMessageFilter { return f.SyslogCiscoFtdFilter },
+		bodyFormat:  func(b models.SyslogBodyFormats) string { return b.SyslogCiscoFtdBodyFormat },
+		filterRule: func(v string) string {
+			return fmt.Sprintf(`match('%%(FTD|ASA)-[0-7]-%s\b' template("$PROGRAM: $MSG") type(pcre))`, regexp.QuoteMeta(v))
+		},
+	},
+	{
+		id:          "ise",
+		name:        "Cisco ISE",
+		path:        "/ise",
+		body:        syslogBody,
+		defaultPort: 1025,
+		enabled:     func(s models.SyslogServices) bool { return s.SyslogCiscoIseEnabled },
+		port:        func(p models.SyslogPorts) int { return p.SyslogCiscoIsePort },
+		setPort:     func(p *models.SyslogPorts, v int) { p.SyslogCiscoIsePort = v },
+		allowlist:   func(a models.SyslogAllowlists) []string { return a.SyslogCiscoIseAllowlist },
+		filter:      func(f models.SyslogFilters) models.SyslogMessageFilter { return f.SyslogCiscoIseFilter },
+		bodyFormat:  func(b models.SyslogBodyFormats) string { return b.SyslogCiscoIseBodyFormat },
+		filterRule: func(v string) string {
+			return fmt.Sprintf(`match('\s%s\s+(DEBUG|INFO|NOTICE|WARN|WARNING|ERROR|FATAL)\s' value("MESSAGE") type(pcre))`, regexp.QuoteMeta(v))
+		},
+	},
+	{
+		id:          "opnsense",
+		name:        "OPNsense",
+		path:        "/opnsense",
+		body:        syslogBody,
+		defaultPort: 1026,
+		enabled:     func(s models.SyslogServices) bool { return s.SyslogOpnsenseEnabled },
+		port:        func(p models.SyslogPorts) int { return p.SyslogOpnsensePort },
+		setPort:     func(p *models.SyslogPorts, v int) { p.SyslogOpnsensePort = v },
+		allowlist:   func(a models.SyslogAllowlists) []string { return a.SyslogOpnsenseAllowlist },
+		filter:      func(f models.SyslogFilters) models.SyslogMessageFilter { return f.SyslogOpnsenseFilter },
+		bodyFormat:  func(b models.SyslogBodyFormats) string { return b.SyslogOpnsenseBodyFormat },
+		filterRule: func(v string) string {
+			return fmt.Sprintf(`program("%s" type(string))`, v)
+		},
+	},
+	{
+		id:              "suricata",
+		name:            "Suricata",
+		path:            "/suricata",
+		body:            "<$PRI>$YEAR-$MONTH-$DAYT$HOUR:$MIN:$SEC.$MSEC $HOST $PROGRAM $MSG",
+		defaultPort:     1027,
+		includeInternal: true,
+		enabled:         func(s models.SyslogServices) bool { return s.SyslogSuricataEnabled },
+		port:            func(p models.SyslogPorts) int { return p.SyslogSuricataPort },
+		setPort:         func(p *models.SyslogPorts, v int) { p.SyslogSuricataPort = v },
+		allowlist:       func(a models.SyslogAllowlists) []string { return a.SyslogSuricataAllowlist },
+		filter:          func(f models.SyslogFilters) models.SyslogMessageFilter { return f.SyslogSuricataFilter },
+		bodyFormat:      func(b models.SyslogBodyFormats) string { return b.SyslogSuricataBodyFormat },
+		filterRule: func(v string) string {
+			return fmt.Sprintf(`match('"event_type":\s*"%s"' value("MESSAGE") type(pcre))`, regexp.QuoteMeta(v))
+		},
+	},
+}
+
+// EffectiveSyslogPorts resolves the listener port of every service.
+// Local overrides win over controller values, which win over the defaults.
+func (c *Config) EffectiveSyslogPorts() models.SyslogPorts {
+	var ports models.SyslogPorts
+	for _, svc := range syslogServiceDefs {
+		port := svc.defaultPort
+		if p := svc.port(c.SyslogPorts); p != 0 {
+			port = p
+		}
+		if p := svc.port(c.SyslogPortOverrides); p != 0 {
+			port = p
+		}
+		svc.setPort(&ports, port)
+	}
+	return ports
+}
+
+// effectiveSyslogAllowlist returns the sender CIDRs allowed for a service.
+// A non-empty local list replaces the one sent by the controller.
+func (c *Config) effectiveSyslogAllowlist(svc syslogServiceDef) []string {
+	if local := svc.allowlist(c.SyslogAllowlistOverrides); len(local) > 0 {
+		return local
+	}
+	return svc.allowlist(c.SyslogAllowlists)
+}
+
+// syslogInstance is one source rendered into syslog-ng.conf, either the
+// default instance of an enabled service or a named instance from the controller.
+type syslogInstance struct {
+	svc        syslogServiceDef
+	id         string // suffix of the syslog-ng object names
+	name       string // empty for the default instance
+	port       int
+	tag        string
+	headers    map[string]string
+	allowlist  []string
+	filter     models.SyslogMessageFilter
+	bodyFormat string
+}
+
+// label identifies the instance in logs and errors
+func (i syslogInstance) label() string {
+	if i.name == "" {
+		return i.svc.name
+	}
+	return fmt.Sprintf("%s (%s)", i.svc.name, i.name)
+}
+
+var (
+	instanceNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	headerNamePattern   = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+)
+
+// syslogInstances returns every syslog source to run: the default instance of
+// each enabled service followed by the named instances.
+func (c *Config) syslogInstances() ([]syslogInstance, error) {
+	ports := c.EffectiveSyslogPorts()
+
+	var instances []syslogInstance
+	for _, svc := range syslogServiceDefs {
+		if !svc.enabled(c.SyslogServices) {
+			continue
+		}
+		instances = append(instances, syslogInstance{
+			svc:        svc,
+			id:         svc.id,
+			port:       svc.port(ports),
+			allowlist:  c.effectiveSyslogAllowlist(svc),
+			filter:     svc.filter(c.SyslogFilters),
+			bodyFormat: svc.bodyFormat(c.SyslogBodyFormats),
+		})
+	}
+
+	seen := map[string]bool{}
+	for _, inst := range c.SyslogInstances {
+		if !instanceNamePattern.MatchString(inst.Name) {
+			return nil, fmt.Errorf("invalid syslog instance name %q", inst.Name)
+		}
+		// "-" and "_" map to the same syslog-ng object name
+		key := strings.ReplaceAll(inst.Name, "-", "_")
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate syslog instance name %q", inst.Name)
+		}
+		seen[key] = true
+
+		idx := slices.IndexFunc(syslogServiceDefs, func(d syslogServiceDef) bool { return d.id == inst.Type })
+		if idx < 0 {
+			return nil, fmt.Errorf("syslog instance %s has unknown type %q", inst.Name, inst.Type)
+		}
+		for k, v := range inst.Headers {
+			if !headerNamePattern.MatchString(k) || strings.ContainsAny(v, "\"\r\n") {
+				return nil, fmt.Errorf("syslog instance %s has invalid header %q", inst.Name, k)
+			}
+		}
+		if strings.ContainsAny(inst.Tag, syslogTemplateUnsafe) {
+			return nil, fmt.Errorf("syslog instance %s has invalid tag %q", inst.Name, inst.Tag)
+		}
+
+		svc := syslogServiceDefs[idx]
+		instances = append(instances, syslogInstance{
+			svc:        svc,
+			id:         svc.id + "_" + key,
+			name:       inst.Name,
+			port:       inst.Port,
+			tag:        inst.Tag,
+			headers:    inst.Headers,
+			allowlist:  inst.Allowlist,
+			filter:     inst.Filter,
+			bodyFormat: inst.BodyFormat,
+		})
+	}
+
+	owners := map[int]string{}
+	for _, inst := range instances {
+		if inst.port < 1 || inst.port > 65535 {
+			return nil, fmt.Errorf("%s: invalid udp port %d", inst.label(), inst.port)
+		}
+		if owner, ok := owners[inst.port]; ok {
+			return nil, fmt.Errorf("port conflict: %s and %s are both configured for udp port %d", owner, inst.label(), inst.port)
+		}
+		owners[inst.port] = inst.label()
+	}
+
+	return instances, nil
+}
+
+// enabledSyslogPorts returns the ports of all syslog instances,
+// in the order they are published by the nfg-syslog container.
+func (c *Config) enabledSyslogPorts() []int {
+	instances, err := c.syslogInstances()
+	if err != nil {
+		return nil
+	}
+
+	var result []int
+	for _, inst := range instances {
+		result = append(result, inst.port)
+	}
+	return result
+}
+
+// effectiveSyslogDelivery fills the unset delivery settings from the controller
+// with the aggregator defaults.
+func (c *Config) effectiveSyslogDelivery() models.SyslogDelivery {
+	d := c.SyslogDelivery
+	if d.BatchLines == 0 {
+		d.BatchLines = defaultSyslogDelivery.BatchLines
+	}
+	if d.BatchBytes == 0 {
+		d.BatchBytes = defaultSyslogDelivery.BatchBytes
+	}
+	if d.BatchTimeoutMs == 0 {
+		d.BatchTimeoutMs = defaultSyslogDelivery.BatchTimeoutMs
+	}
+	if d.Framing == "" {
+		d.Framing = defaultSyslogDelivery.Framing
+	}
+	if d.TimeoutSeconds == 0 {
+		d.TimeoutSeconds = defaultSyslogDelivery.TimeoutSeconds
+	}
+	return d
+}
+
+// filter values end up inside syslog-ng regular expressions and strings,
+// rules use single-quoted strings so regex escapes are passed through as is
+var filterValuePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// messageFilterExpression renders the include/exclude rules of a service as a
+// syslog-ng filter expression matching the messages to keep. It returns an
+// empty string if the service has no rules.
+func messageFilterExpression(svc syslogServiceDef, f models.SyslogMessageFilter) (string, error) {
+	render := func(values []string) (string, error) {
+		var terms []string
+		for _, v := range values {
+			if !filterValuePattern.MatchString(v) {
+				return "", fmt.Errorf("invalid filter value %q", v)
+			}
+			terms = append(terms, svc.filterRule(v))
+		}
+		return "(" + strings.Join(terms, " or ") + ")", nil
+	}
+
+	var parts []string
+	if len(f.Include) > 0 {
+		include, err := render(f.Include)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, include)
+	}
+	if len(f.Exclude) > 0 {
+		exclude, err := render(f.Exclude)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, "not "+exclude)
+	}
+	return strings.Join(parts, " and "), nil
+}
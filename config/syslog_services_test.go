@@ -0,0 +1,73 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/models"
+)
+
+func TestSyslogInstancesTags(t *testing.T) {
+	for _, tt := range []struct {
+		tag   string
+		valid bool
+	}{
+		{"", true},
+		{"dc-berlin 01", true},
+		{"$HOST", false},
+		{`dc\berlin`, false},
+		{`dc"berlin`, false},
+		{"dc'berlin", false},
+		{"dc(berlin)", false},
+		{"dc\nberlin", false},
+	} {
+		c := &Config{SyslogInstances: []models.SyslogInstance{{Name: "ftd", Type: "firepower", Port: 1514, Tag: tt.tag}}}
+		instances, err := c.syslogInstances()
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("tag %q: no error", tt.tag)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("tag %q: %v", tt.tag, err)
+			continue
+		}
+		if body := syslogJSONBody(instances[0], "tpot-01"); !strings.Contains(body, `tag="`+tt.tag+`"`) {
+			t.Errorf("tag %q missing in %s", tt.tag, body)
+		}
+	}
+}
+
+func TestSyslogInstancesPorts(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		ports []int
+		err   string
+	}{
+		{"distinct", []int{1514, 1515}, ""},
+		{"zero", []int{0}, "invalid udp port 0"},
+		{"too large", []int{65536}, "invalid udp port 65536"},
+		{"same as the default instance", []int{1026}, "OPNsense and"},
+		{"same as another instance", []int{1514, 1514}, "are both configured for udp port 1514"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{SyslogServices: models.SyslogServices{SyslogOpnsenseEnabled: true}}
+			for i, port := range tt.ports {
+				c.SyslogInstances = append(c.SyslogInstances, models.SyslogInstance{
+					Name: "ftd-" + string(rune('a'+i)),
+					Type: "firepower",
+					Port: port,
+				})
+			}
+
+			_, err := c.syslogInstances()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
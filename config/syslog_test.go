@@ -0,0 +1,348 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/assets"
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/docker"
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/models"
+)
+
+// freeUDPPort returns a UDP port that is not bound on the host
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// syslogTestRuntime is a fake runtime recording the syslog-ng-ctl commands
+// run in nfg-syslog. Syntax checks fail while failSyntax is set.
+type syslogTestRuntime struct {
+	*docker.Fake
+
+	mu         sync.Mutex
+	commands   []string
+	failSyntax bool
+}
+
+func newSyslogTestRuntime(t *testing.T) *syslogTestRuntime {
+	rt := &syslogTestRuntime{Fake: useFakeRuntime(t)}
+	rt.ExecFunc = func(container string, cmd []string) (string, int, error) {
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+		rt.commands = append(rt.commands, strings.Join(cmd[:2], " "))
+		return "", 0, nil
+	}
+	rt.WaitFunc = func(spec docker.ContainerSpec) (string, int) {
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+		if slices.Contains(spec.Cmd, "--syntax-only") && rt.failSyntax {
+			return "Error parsing config, syntax error in /tmp/syslog-ng.conf:12:3", 1
+		}
+		return "", 0
+	}
+	t.Cleanup(assets.Cleanup)
+	return rt
+}
+
+func (rt *syslogTestRuntime) setFailSyntax(fail bool) {
+	rt.mu.Lock()
+	rt.failSyntax = fail
+	rt.mu.Unlock()
+}
+
+func (rt *syslogTestRuntime) reloads() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	n := 0
+	for _, cmd := range rt.commands {
+		if cmd == "syslog-ng-ctl reload" {
+			n++
+		}
+	}
+	return n
+}
+
+// containerID returns the ID of the running nfg-syslog container
+func (rt *syslogTestRuntime) containerID(t *testing.T) string {
+	t.Helper()
+	info, err := rt.ContainerInspect(context.Background(), "nfg-syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.State.Running {
+		t.Fatal("nfg-syslog is not running")
+	}
+	return info.ID
+}
+
+func newSyslogTestConfig(t *testing.T) *Config {
+	return &Config{
+		AggregatorName:        "tpot-01",
+		AuthSecret:            "secret",
+		NfgThreatCollectorUrl: "https://collector.example.com",
+		SyslogEnabled:         true,
+		SyslogServices:        models.SyslogServices{SyslogOpnsenseEnabled: true},
+		SyslogPortOverrides:   models.SyslogPorts{SyslogOpnsensePort: freeUDPPort(t)},
+		SyslogHealthTimeout:   10 * time.Second,
+	}
+}
+
+func TestDeploySyslogReloadsOrRecreates(t *testing.T) {
+	rt := newSyslogTestRuntime(t)
+	c := newSyslogTestConfig(t)
+
+	HandleSyslogChange(c)
+	id := rt.containerID(t)
+	if spec, _ := rt.Spec("nfg-syslog"); len(spec.Ports) != 1 || spec.Ports[0].Port != c.SyslogPortOverrides.SyslogOpnsensePort {
+		t.Fatalf("got ports %v", spec.Ports)
+	}
+
+	// an allowlist only changes the config, the container is kept
+	c.SyslogAllowlists.SyslogOpnsenseAllowlist = []string{"192.0.2.0/24"}
+	HandleSyslogChange(c)
+	if got := rt.containerID(t); got != id {
+		t.Errorf("container was recreated for a config change")
+	}
+	if rt.reloads() != 1 {
+		t.Errorf("got %d reloads, want 1", rt.reloads())
+	}
+	if !strings.Contains(c.SyslogConfig, "192.0.2.0") {
+		t.Error("new config was not applied")
+	}
+
+	// an unchanged config is neither reloaded nor recreated
+	HandleSyslogChange(c)
+	if got := rt.containerID(t); got != id || rt.reloads() != 1 {
+		t.Errorf("unchanged config touched the container")
+	}
+
+	// published ports are part of the container
+	port := freeUDPPort(t)
+	c.SyslogPortOverrides.SyslogOpnsensePort = port
+	HandleSyslogChange(c)
+	if got := rt.containerID(t); got == id {
+		t.Errorf("container was not recreated for a port change")
+	}
+	if spec, _ := rt.Spec("nfg-syslog"); len(spec.Ports) != 1 || spec.Ports[0].Port != port {
+		t.Errorf("got ports %v, want %d", spec.Ports, port)
+	}
+	if rt.reloads() != 1 {
+		t.Errorf("got %d reloads, want 1", rt.reloads())
+	}
+}
+
+func TestDeploySyslogKeepsConfigOnSyntaxError(t *testing.T) {
+	rt := newSyslogTestRuntime(t)
+	c := newSyslogTestConfig(t)
+
+	HandleSyslogChange(c)
+	id := rt.containerID(t)
+	prevConfig, prevPorts := c.SyslogConfig, c.SyslogPublishedPorts
+
+	rt.setFailSyntax(true)
+	c.SyslogAllowlists.SyslogOpnsenseAllowlist = []string{"192.0.2.0/24"}
+	HandleSyslogChange(c)
+	if c.SyslogConfig != prevConfig || !slices.Equal(c.SyslogPublishedPorts, prevPorts) {
+		t.Error("config that failed the syntax check was kept")
+	}
+	if got := rt.containerID(t); got != id || rt.reloads() != 0 {
+		t.Error("running container was touched")
+	}
+
+	// same for changes that would recreate the container
+	c.SyslogPortOverrides.SyslogOpnsensePort = freeUDPPort(t)
+	HandleSyslogChange(c)
+	if c.SyslogConfig != prevConfig || !slices.Equal(c.SyslogPublishedPorts, prevPorts) {
+		t.Error("config that failed the syntax check was kept")
+	}
+	if got := rt.containerID(t); got != id {
+		t.Error("running container was replaced")
+	}
+
+	// the next valid config is applied
+	rt.setFailSyntax(false)
+	HandleSyslogChange(c)
+	if c.SyslogConfig == prevConfig || rt.containerID(t) == id {
+		t.Error("valid config was not deployed")
+	}
+}
+
+func TestHandleSyslogChangeStopsDisabledSyslog(t *testing.T) {
+	rt := newSyslogTestRuntime(t)
+	c := newSyslogTestConfig(t)
+
+	HandleSyslogChange(c)
+	rt.containerID(t)
+
+	c.SyslogEnabled = false
+	HandleSyslogChange(c)
+	if ContainerRunning("nfg-syslog") {
+		t.Error("nfg-syslog still runs")
+	}
+}
+
+func TestSyslogDiskBufferUsage(t *testing.T) {
+	fake := useFakeRuntime(t)
+	t.Cleanup(assets.Cleanup)
+	fake.ExecFunc = func(container string, cmd []string) (string, int, error) {
+		if !slices.Contains(cmd, "prometheus") {
+			return "", 0, nil
+		}
+		return `# TYPE syslogng_disk_queue_capacity_bytes gauge
+syslogng_disk_queue_capacity_bytes{driver_id="d_http_opnsense#0",path="/config/buffers/syslog-ng-00000.qf",reliable="false"} 1073741824
+syslogng_disk_queue_disk_usage_bytes{driver_id="d_http_opnsense#0",path="/config/buffers/syslog-ng-00000.qf",reliable="false"} 524288
+syslogng_disk_queue_disk_usage_bytes{driver_id="d_http_suricata_dmz#0",path="/config/buffers/syslog-ng-00001.qf",reliable="false"} 4096
+syslogng_disk_queue_events{driver_id="d_http_opnsense#0",path="/config/buffers/syslog-ng-00000.qf",reliable="false"} 12
+syslogng_output_events_total{driver_id="d_rejected_opnsense#0",result="delivered"} 3
+`, 0, nil
+	}
+
+	c := newSyslogTestConfig(t)
+	c.SyslogDiskBufferEnabled = true
+	c.SyslogDiskBufferSizeMB = 512
+	HandleSyslogChange(c)
+
+	usage, err := SyslogDiskBufferUsage(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]DiskBufferUsage{
+		"opnsense":     {UsedBytes: 524288, CapacityBytes: 1 << 30},
+		"suricata_dmz": {UsedBytes: 4096, CapacityBytes: 512 << 20}, // from the config
+	}
+	if !maps.Equal(usage, want) {
+		t.Errorf("got %v, want %v", usage, want)
+	}
+
+	c.SyslogDiskBufferEnabled = false
+	if usage, err := SyslogDiskBufferUsage(c); err != nil || usage != nil {
+		t.Errorf("got %v, %v without disk buffers", usage, err)
+	}
+}
+
+func TestParsePrometheusSample(t *testing.T) {
+	tests := []struct {
+		line   string
+		name   string
+		labels map[string]string
+		value  int64
+		ok     bool
+	}{
+		{line: `up 1`, name: "up", labels: map[string]string{}, value: 1, ok: true},
+		{line: `m{a="x",b="y z"} 42`, name: "m", labels: map[string]string{"a": "x", "b": "y z"}, value: 42, ok: true},
+		{line: `m{a="q\"uo\\te\n"} 1e3`, name: "m", labels: map[string]string{"a": "q\"uo\\te\n"}, value: 1000, ok: true},
+		{line: `# HELP m help`},
+		{line: ``},
+		{line: `m{a="x"} NaNx`},
+		{line: `m{a="x" 1`},
+		{line: `m{a="x} 1`},
+	}
+	for _, tt := range tests {
+		name, labels, value, ok := parsePrometheusSample(tt.line)
+		if ok != tt.ok || name != tt.name || value != tt.value || !maps.Equal(labels, tt.labels) {
+			t.Errorf("%s: got %q %v %d %v", tt.line, name, labels, value, ok)
+		}
+	}
+}
+
+func TestRenderSyslogTransport(t *testing.T) {
+	delivery := models.SyslogDelivery{TimeoutSeconds: 10}
+
+	out, err := renderSyslogTransport(&Config{}, delivery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "ca-file(") || strings.Contains(out, "ca-dir(") {
+		t.Errorf("CA options without TLS_CA_FILE:\n%s", out)
+	}
+
+	out, err = renderSyslogTransport(&Config{TLSCAFile: "/etc/nfg/ca.pem"}, delivery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the custom CA must not replace the system CAs
+	for _, want := range []string{`ca-dir("/etc/ssl/certs")`, `ca-file("/etc/ssl/nfg/ca.pem")`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s:\n%s", want, out)
+		}
+	}
+
+	for _, tt := range []struct {
+		status int
+		action string
+	}{
+		{400, "drop"},
+		{401, "disconnect"},
+		{403, "disconnect"},
+		{408, "retry"},
+		{418, "drop"},
+		{429, "retry"},
+		{499, "drop"},
+		{500, "retry"},
+		{507, "retry"},
+		{599, "retry"},
+	} {
+		if want := fmt.Sprintf("%d => %s", tt.status, tt.action); !strings.Contains(out, want) {
+			t.Errorf("missing response action %q", want)
+		}
+	}
+	if strings.Contains(out, "399 =>") || strings.Contains(out, "600 =>") || !strings.Contains(out, "599 => retry\n") {
+		t.Errorf("unexpected response actions:\n%s", out)
+	}
+
+	if _, err := renderSyslogTransport(&Config{}, models.SyslogDelivery{}); err == nil {
+		t.Error("no error for a zero timeout")
+	}
+}
+
+func TestGenerateSyslogConfigAggregatorName(t *testing.T) {
+	c := newSyslogTestConfig(t)
+	c.AggregatorName = `tpot-$HOST"\`
+
+	// the text body doesn't carry the name, the header escapes it
+	if err := generateSyslogConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	if want := `"X-AGGREGATOR_NAME: tpot-$HOST\"\\"`; !strings.Contains(c.SyslogConfig, want) {
+		t.Errorf("config doesn't contain %s:\n%s", want, c.SyslogConfig)
+	}
+
+	c.SyslogBodyFormats = models.SyslogBodyFormats{SyslogOpnsenseBodyFormat: bodyFormatJSON}
+	if err := generateSyslogConfig(c); err == nil {
+		t.Error("no error for an aggregator name the JSON body can't carry")
+	}
+}
+
+func TestSyslogHeadersEscapeValues(t *testing.T) {
+	c := &Config{
+		AggregatorName: "tpot-01",
+		SyslogInstances: []models.SyslogInstance{{
+			Name:    "ftd",
+			Type:    "firepower",
+			Port:    1514,
+			Headers: map[string]string{"X-Site": `C:\dc\`},
+		}},
+	}
+	instances, err := c.syslogInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := syslogHeaders(c, instances[0], framingNewline, bodyFormatText)
+	if want := `"X-Site: C:\\dc\\"`; !strings.Contains(headers, want) {
+		t.Errorf("headers = %s, want %s", headers, want)
+	}
+}
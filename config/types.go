@@ -3,10 +3,19 @@ package config
 import "github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/models"
 
 type UpdatedConfig struct {
-	Name            string                `json:"name"`
-	SyslogEnabled   bool                  `json:"syslogEnabled"`
-	SyslogServices  models.SyslogServices `json:"syslogServices"`
-	LogstashEnabled bool                  `json:"logstashEnabled"`
+	Name                 string                      `json:"name"`
+	SyslogEnabled        bool                        `json:"syslogEnabled"`
+	SyslogServices       models.SyslogServices       `json:"syslogServices"`
+	SyslogPorts          models.SyslogPorts          `json:"syslogPorts"`
+	SyslogAllowlists     models.SyslogAllowlists     `json:"syslogAllowlists"`
+	SyslogFilters        models.SyslogFilters        `json:"syslogFilters"`
+	SyslogBodyFormats    models.SyslogBodyFormats    `json:"syslogBodyFormats"`
+	SyslogInstances      []models.SyslogInstance     `json:"syslogInstances,omitempty"`
+	SyslogDelivery       models.SyslogDelivery       `json:"syslogDelivery"`
+	LogstashEnabled      bool                        `json:"logstashEnabled"`
+	LogstashSettings     models.LogstashSettings     `json:"logstashSettings"`
+	LogstashFilter       models.LogstashFilter       `json:"logstashFilter"`
+	ElasticsearchTargets []RemoteElasticsearchTarget `json:"elasticsearchTargets,omitempty"`
 }
 
 type ConfigResponse struct {
@@ -14,13 +23,38 @@ type ConfigResponse struct {
 }
 
 type ElasticsearchTarget struct {
-	URL      string `json:"url"`
-	User     string `json:"user,omitempty"`
-	Password string `json:"pass,omitempty"`
+	URL                  string `json:"url"`
+	User                 string `json:"user,omitempty"`
+	Password             string `json:"pass,omitempty"`
+	APIKey               string `json:"api_key,omitempty"`                // "id:key", Elasticsearch only
+	CAFile               string `json:"ca_file,omitempty"`                // PEM file on the host
+	CATrustedFingerprint string `json:"ca_trusted_fingerprint,omitempty"` // hex SHA-256, Elasticsearch only
+	VerificationMode     string `json:"verification_mode,omitempty"`      // "full" (default) or "none"
+	Index                string `json:"index,omitempty"`                  // overrides LOGSTASH_ES_INDEX
+	Flavor               string `json:"flavor,omitempty"`                 // "elasticsearch" (default) or "opensearch"
+}
+
+// RemoteElasticsearchTarget is a target managed by the controller. Secrets
+// are never sent in plain text: Credentials is the base64 encoding of
+// nonce || AES-256-GCM ciphertext of {"pass":...,"api_key":...}, keyed with
+// HMAC-SHA256(AUTH_SECRET, "nfgtfa elasticsearch credentials v1") and
+// authenticated with "<aggregator name>|<url>" as additional data.
+type RemoteElasticsearchTarget struct {
+	ElasticsearchTarget
+	Credentials string `json:"credentials,omitempty"`
 }
 
 type RemoteConfig struct {
-	SyslogEnabled   bool                  `json:"syslogEnabled"`
-	LogstashEnabled bool                  `json:"logstashEnabled"`
-	SyslogServices  models.SyslogServices `json:"syslogServices"`
+	SyslogEnabled        bool                        `json:"syslogEnabled"`
+	LogstashEnabled      bool                        `json:"logstashEnabled"`
+	SyslogServices       models.SyslogServices       `json:"syslogServices"`
+	SyslogPorts          models.SyslogPorts          `json:"syslogPorts"`
+	SyslogAllowlists     models.SyslogAllowlists     `json:"syslogAllowlists"`
+	SyslogFilters        models.SyslogFilters        `json:"syslogFilters"`
+	SyslogBodyFormats    models.SyslogBodyFormats    `json:"syslogBodyFormats"`
+	SyslogInstances      []models.SyslogInstance     `json:"syslogInstances,omitempty"`
+	SyslogDelivery       models.SyslogDelivery       `json:"syslogDelivery"`
+	LogstashSettings     models.LogstashSettings     `json:"logstashSettings"`
+	LogstashFilter       models.LogstashFilter       `json:"logstashFilter"`
+	ElasticsearchTargets []RemoteElasticsearchTarget `json:"elasticsearchTargets,omitempty"`
 }
@@ -1,7 +1,6 @@
 package config
 
 import (
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"io"
@@ -77,15 +76,26 @@ func (u *ConfigUpdaterWsImpl) StartListening(cfg *Config) {
 				}
 				// update cfg with received values
 				cfg.ApplyRemoteConfig(RemoteConfig{
-					SyslogEnabled:   data.SyslogEnabled,
-					LogstashEnabled: data.LogstashEnabled,
-					SyslogServices:  data.SyslogServices,
+					SyslogEnabled:        data.SyslogEnabled,
+					LogstashEnabled:      data.LogstashEnabled,
+					SyslogServices:       data.SyslogServices,
+					SyslogPorts:          data.SyslogPorts,
+					SyslogAllowlists:     data.SyslogAllowlists,
+					SyslogFilters:        data.SyslogFilters,
+					SyslogBodyFormats:    data.SyslogBodyFormats,
+					SyslogInstances:      data.SyslogInstances,
+					SyslogDelivery:       data.SyslogDelivery,
+					LogstashSettings:     data.LogstashSettings,
+					LogstashFilter:       data.LogstashFilter,
+					ElasticsearchTargets: data.ElasticsearchTargets,
 				})
 
 				zap.L().Info("Stored config",
 					zap.Bool("syslogEnabled", cfg.SyslogEnabled),
 					zap.Bool("logstashEnabled", cfg.LogstashEnabled),
 					zap.Any("syslogServices", cfg.SyslogServices),
+					zap.Any("syslogPorts", cfg.EffectiveSyslogPorts()),
+					zap.Int("syslogInstances", len(cfg.SyslogInstances)),
 				)
 			}
 		}
@@ -108,13 +118,15 @@ func StartWebSocketClient(cfg *Config, configUpdater *ConfigUpdaterWsImpl) error
 	headers := http.Header{}
 	headers.Set("X_AUTH_KEY", cfg.AuthSecret)
 	headers.Set("X_AGGREGATOR_NAME", cfg.AggregatorName)
+	headers.Set("X_AGGREGATOR_IMAGES", ImageReport())
 
-	dialer := websocket.DefaultDialer
-	if cfg.InsecureSkipVerifyTLS {
-		dialer.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
+	tlsConfig, err := cfg.TLSConfig()
+	if err != nil {
+		zap.L().Error("Failed to create websocket TLS config", zap.Error(err))
+		return err
 	}
+	dialer := websocket.DefaultDialer
+	dialer.TLSClientConfig = tlsConfig
 
 	for {
 		zap.L().Info("Connecting to config updater websocket", zap.String("url", u.String()))
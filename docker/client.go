@@ -0,0 +1,159 @@
+// Package docker is a small client for the Docker Engine API, covering what
+// the aggregator needs to run its containers. It talks to the daemon over
+// its Unix socket, so neither the docker CLI nor the compose plugin is needed.
+// Podman serves the same API, the differences are handled by Podman. Fake
+// is an in-memory Runtime for tests.
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// DefaultSocket is used if DOCKER_HOST is not set
+const DefaultSocket = "/var/run/docker.sock"
+
+// apiVersion is the oldest Engine API version with everything used here
+// (Docker 20.10), newer daemons still serve it
+const apiVersion = "v1.41"
+
+// Client talks to the Docker Engine API. All methods honour the deadline and
+// cancellation of their context.
+type Client struct {
+	http *http.Client
+}
+
+// NewClient returns a client for the daemon listening on the given Unix socket
+func NewClient(socket string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}
+	return &Client{http: &http.Client{Transport: transport}}
+}
+
+// FromEnv returns a client for the daemon in DOCKER_HOST, or the default
+// socket. Only unix:// hosts are supported.
+func FromEnv() (*Client, error) {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		return NewClient(DefaultSocket), nil
+	}
+	socket, err := socketFromHost("DOCKER_HOST", host)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(socket), nil
+}
+
+// APIError is an error response of the daemon
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("docker API error %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from the daemon, e.g. for a
+// missing container, network or image
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Ping checks that the daemon is reachable
+func (c *Client) Ping(ctx context.Context) error {
+	resp, err := c.request(ctx, http.MethodGet, "/_ping", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// request sends a request to the daemon and returns the response if its
+// status is below 400. The caller must close the body.
+func (c *Client) request(ctx context.Context, method string, path string, query url.Values, body any) (*http.Response, error) {
+	return c.requestWithHeader(ctx, method, path, query, body, nil)
+}
+
+// requestWithHeader is request with additional headers
+func (c *Client) requestWithHeader(ctx context.Context, method string, path string, query url.Values, body any, header http.Header) (*http.Response, error) {
+	// readers are sent as they are, they only carry tarballs here
+	var reader io.Reader
+	contentType := "application/x-tar"
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reader = b
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	u := "http://docker/" + apiVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("docker API request %s %s failed: %w", method, path, err)
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		var msg struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &msg) != nil || msg.Message == "" {
+			msg.Message = strings.TrimSpace(string(data))
+		}
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: msg.Message}
+	}
+	return resp, nil
+}
+
+// do sends a request and decodes the JSON response into out, if not nil
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body any, out any) error {
+	resp, err := c.request(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode docker API response of %s %s: %w", method, path, err)
+	}
+	return nil
+}
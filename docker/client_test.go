@@ -0,0 +1,143 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient serves handler on a temporary Unix socket and returns a
+// client connected to it. Paths are passed to the handler without the API
+// version prefix.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	// socket paths are limited to about 100 bytes, t.TempDir may be longer
+	dir, err := os.MkdirTemp("", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "docker.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, found := strings.CutPrefix(r.URL.Path, "/"+apiVersion)
+		if !found {
+			t.Errorf("request %s %s without API version", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		r.URL.Path = path
+		handler(w, r)
+	}))
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return NewClient(socket)
+}
+
+func TestPing(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/_ping" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte("OK"))
+	})
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		message  string
+		notFound bool
+	}{
+		{"json message", http.StatusNotFound, `{"message":"No such container: nfg-syslog"}`, "No such container: nfg-syslog", true},
+		{"plain text", http.StatusInternalServerError, "daemon is shutting down\n", "daemon is shutting down", false},
+		{"conflict", http.StatusConflict, `{"message":"name is already in use"}`, "name is already in use", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+
+			_, err := client.ContainerInspect(context.Background(), "nfg-syslog")
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected an APIError, got %v", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Message != tt.message {
+				t.Errorf("got %d %q, want %d %q", apiErr.StatusCode, apiErr.Message, tt.status, tt.message)
+			}
+			if IsNotFound(err) != tt.notFound {
+				t.Errorf("IsNotFound = %v, want %v", IsNotFound(err), tt.notFound)
+			}
+		})
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	if IsNotFound(nil) {
+		t.Error("nil is not a 404")
+	}
+	if IsNotFound(errors.New("404")) {
+		t.Error("a plain error is not a 404")
+	}
+	if !IsNotFound(&APIError{StatusCode: http.StatusNotFound}) {
+		t.Error("an APIError with 404 is a 404")
+	}
+}
+
+func TestContextTimeout(t *testing.T) {
+	done := make(chan struct{})
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	})
+	// runs before the server is closed, which waits for the handler
+	t.Cleanup(func() { close(done) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err := client.Exec(ctx, "nfg-syslog", []string{"true"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("request returned after %s", elapsed)
+	}
+}
+
+func TestSocketFromHost(t *testing.T) {
+	socket, err := socketFromHost("DOCKER_HOST", "unix:///run/user/1000/docker.sock")
+	if err != nil || socket != "/run/user/1000/docker.sock" {
+		t.Errorf("got %q, %v", socket, err)
+	}
+	for _, host := range []string{"tcp://127.0.0.1:2375", "unix://", "/var/run/docker.sock"} {
+		if _, err := socketFromHost("DOCKER_HOST", host); err == nil {
+			t.Errorf("expected %q to be rejected", host)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ContainerSpec describes a container to create
+type ContainerSpec struct {
+	Name          string
+	Image         string
+	Entrypoint    []string
+	Cmd           []string
+	Env           []string // "KEY=value"
+	Binds         []string // "host-path-or-volume:container-path[:ro]"
+	Ports         []Port   // published ports
+	NetworkMode   string   // "bridge", "host", "none" or a network name, empty for the daemon default
+	RestartPolicy string   // e.g. "unless-stopped", empty for none
+	Healthcheck   *Healthcheck
+	LogOptions    map[string]string // options of the json-file log driver
+	Labels        map[string]string
+}
+
+// Port publishes a container port on the same port of the host
+type Port struct {
+	Port     int
+	Protocol string // "tcp" or "udp"
+}
+
+func (p Port) key() string {
+	return fmt.Sprintf("%d/%s", p.Port, p.Protocol)
+}
+
+type Healthcheck struct {
+	Test        []string // e.g. ["CMD", "curl", "-f", "http://localhost"]
+	Interval    time.Duration
+	Timeout     time.Duration
+	Retries     int
+	StartPeriod time.Duration
+}
+
+// ContainerInfo is the part of a container inspection the aggregator uses
+type ContainerInfo struct {
+	ID    string `json:"Id"`
+	Name  string `json:"Name"`
+	Image string `json:"Image"`
+	State struct {
+		Status    string    `json:"Status"`
+		Running   bool      `json:"Running"`
+		ExitCode  int       `json:"ExitCode"`
+		StartedAt time.Time `json:"StartedAt"`
+		Health    *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+	Config struct {
+		Image string `json:"Image"`
+	} `json:"Config"`
+}
+
+// HealthStatus returns the healthcheck status ("starting", "healthy",
+// "unhealthy"), or the container state if it has no healthcheck
+func (i *ContainerInfo) HealthStatus() string {
+	if i.State.Health != nil {
+		return i.State.Health.Status
+	}
+	return i.State.Status
+}
+
+type createRequest struct {
+	Image        string              `json:"Image"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Healthcheck  *healthcheckConfig  `json:"Healthcheck,omitempty"`
+	HostConfig   hostConfig          `json:"HostConfig"`
+}
+
+type healthcheckConfig struct {
+	Test        []string `json:"Test"`
+	Interval    int64    `json:"Interval,omitempty"`
+	Timeout     int64    `json:"Timeout,omitempty"`
+	Retries     int      `json:"Retries,omitempty"`
+	StartPeriod int64    `json:"StartPeriod,omitempty"`
+}
+
+type hostConfig struct {
+	Binds         []string                 `json:"Binds,omitempty"`
+	PortBindings  map[string][]portBinding `json:"PortBindings,omitempty"`
+	NetworkMode   string                   `json:"NetworkMode,omitempty"`
+	RestartPolicy struct {
+		Name string `json:"Name,omitempty"`
+	} `json:"RestartPolicy"`
+	LogConfig *logConfig `json:"LogConfig,omitempty"`
+}
+
+type portBinding struct {
+	HostPort string `json:"HostPort"`
+}
+
+type logConfig struct {
+	Type   string            `json:"Type"`
+	Config map[string]string `json:"Config,omitempty"`
+}
+
+// ContainerCreate creates a container and returns its ID. The image must be
+// present, it is not pulled.
+func (c *Client) ContainerCreate(ctx context.Context, spec ContainerSpec) (string, error) {
+	req := createRequest{
+		Image:      spec.Image,
+		Entrypoint: spec.Entrypoint,
+		Cmd:        spec.Cmd,
+		Env:        spec.Env,
+		Labels:     spec.Labels,
+		HostConfig: hostConfig{
+			Binds:       spec.Binds,
+			NetworkMode: spec.NetworkMode,
+		},
+	}
+	req.HostConfig.RestartPolicy.Name = spec.RestartPolicy
+	if len(spec.Ports) > 0 {
+		req.ExposedPorts = map[string]struct{}{}
+		req.HostConfig.PortBindings = map[string][]portBinding{}
+		for _, p := range spec.Ports {
+			req.ExposedPorts[p.key()] = struct{}{}
+			req.HostConfig.PortBindings[p.key()] = []portBinding{{HostPort: strconv.Itoa(p.Port)}}
+		}
+	}
+	if h := spec.Healthcheck; h != nil {
+		req.Healthcheck = &healthcheckConfig{
+			Test:        h.Test,
+			Interval:    int64(h.Interval),
+			Timeout:     int64(h.Timeout),
+			Retries:     h.Retries,
+			StartPeriod: int64(h.StartPeriod),
+		}
+	}
+	if spec.LogOptions != nil {
+		req.HostConfig.LogConfig = &logConfig{Type: "json-file", Config: spec.LogOptions}
+	}
+
+	query := url.Values{}
+	if spec.Name != "" {
+		query.Set("name", spec.Name)
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/containers/create", query, req, &created); err != nil {
+		return "", fmt.Errorf("failed to create container %s: %w", spec.Name, err)
+	}
+	return created.ID, nil
+}
+
+// ContainerStart starts a created or stopped container
+func (c *Client) ContainerStart(ctx context.Context, id string) error {
+	if err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/start", nil, nil, nil); err != nil {
+		return fmt.Errorf("failed to start container %s: %w", id, err)
+	}
+	return nil
+}
+
+// ContainerStop stops a container, killing it if it doesn't exit within the
+// timeout. Stopping a stopped container is not an error. The context must
+// allow for the timeout.
+func (c *Client) ContainerStop(ctx context.Context, id string, timeout time.Duration) error {
+	query := url.Values{"t": {strconv.Itoa(int(timeout.Seconds()))}}
+	if err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/stop", query, nil, nil); err != nil {
+		return fmt.Errorf("failed to stop container %s: %w", id, err)
+	}
+	return nil
+}
+
+// ContainerRemove removes a container, killing it first if force is set.
+// Named volumes are kept.
+func (c *Client) ContainerRemove(ctx context.Context, id string, force bool) error {
+	query := url.Values{"force": {strconv.FormatBool(force)}}
+	if err := c.do(ctx, http.MethodDelete, "/containers/"+url.PathEscape(id), query, nil, nil); err != nil {
+		return fmt.Errorf("failed to remove container %s: %w", id, err)
+	}
+	return nil
+}
+
+// ContainerInspect returns the state of a container by name or ID. A missing
+// container is reported as an error satisfying IsNotFound.
+func (c *Client) ContainerInspect(ctx context.Context, id string) (*ContainerInfo, error) {
+	var info ContainerInfo
+	if err := c.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/json", nil, nil, &info); err != nil {
+		return nil, fmt.Errorf("failed to inspect container %s: %w", id, err)
+	}
+	return &info, nil
+}
+
+// ContainerWait waits until a container exits and returns its exit code
+func (c *Client) ContainerWait(ctx context.Context, id string) (int, error) {
+	var result struct {
+		StatusCode int `json:"StatusCode"`
+		Error      *struct {
+			Message string `json:"Message"`
+		} `json:"Error"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/wait", nil, nil, &result); err != nil {
+		return 0, fmt.Errorf("failed to wait for container %s: %w", id, err)
+	}
+	if result.Error != nil && result.Error.Message != "" {
+		return result.StatusCode, fmt.Errorf("failed to wait for container %s: %s", id, result.Error.Message)
+	}
+	return result.StatusCode, nil
+}
+
+// ContainerLogs returns the combined stdout and stderr of a container
+// without a TTY
+func (c *Client) ContainerLogs(ctx context.Context, id string) (string, error) {
+	query := url.Values{"stdout": {"true"}, "stderr": {"true"}}
+	resp, err := c.request(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/logs", query, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to read logs of container %s: %w", id, err)
+	}
+	defer resp.Body.Close()
+
+	var output bytes.Buffer
+	if err := demux(resp.Body, &output); err != nil {
+		return output.String(), fmt.Errorf("failed to read logs of container %s: %w", id, err)
+	}
+	return output.String(), nil
+}
@@ -0,0 +1,160 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestContainerCreate(t *testing.T) {
+	var got createRequest
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/containers/create" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if name := r.URL.Query().Get("name"); name != "nfg-syslog" {
+			t.Errorf("got name %q", name)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("got content type %q", ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"Id":"abc123","Warnings":[]}`))
+	})
+
+	id, err := client.ContainerCreate(context.Background(), ContainerSpec{
+		Name:          "nfg-syslog",
+		Image:         "linuxserver/syslog-ng:4.10.2",
+		Env:           []string{"TZ=Europe/Berlin"},
+		Binds:         []string{"nfg-syslog-data:/config"},
+		Ports:         []Port{{Port: 514, Protocol: "udp"}},
+		RestartPolicy: "unless-stopped",
+		Healthcheck: &Healthcheck{
+			Test:     []string{"CMD", "syslog-ng-ctl", "healthcheck"},
+			Interval: 10 * time.Second,
+			Timeout:  5 * time.Second,
+			Retries:  3,
+		},
+		LogOptions: map[string]string{"max-size": "10m"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "abc123" {
+		t.Errorf("got ID %q", id)
+	}
+
+	if got.Image != "linuxserver/syslog-ng:4.10.2" || !reflect.DeepEqual(got.Env, []string{"TZ=Europe/Berlin"}) {
+		t.Errorf("got image %q and env %v", got.Image, got.Env)
+	}
+	if _, ok := got.ExposedPorts["514/udp"]; !ok {
+		t.Errorf("port not exposed: %v", got.ExposedPorts)
+	}
+	if want := []portBinding{{HostPort: "514"}}; !reflect.DeepEqual(got.HostConfig.PortBindings["514/udp"], want) {
+		t.Errorf("got port bindings %v", got.HostConfig.PortBindings)
+	}
+	if got.HostConfig.RestartPolicy.Name != "unless-stopped" {
+		t.Errorf("got restart policy %q", got.HostConfig.RestartPolicy.Name)
+	}
+	if got.Healthcheck == nil || got.Healthcheck.Interval != int64(10*time.Second) || got.Healthcheck.Retries != 3 {
+		t.Errorf("got healthcheck %+v", got.Healthcheck)
+	}
+	if got.HostConfig.LogConfig == nil || got.HostConfig.LogConfig.Type != "json-file" {
+		t.Errorf("got log config %+v", got.HostConfig.LogConfig)
+	}
+}
+
+func TestContainerLifecycle(t *testing.T) {
+	var requests []string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+		switch r.URL.Path {
+		case "/containers/nfg-syslog/json":
+			w.Write([]byte(`{
+				"Id": "abc123",
+				"Name": "/nfg-syslog",
+				"Image": "sha256:0123",
+				"State": {"Status": "running", "Running": true, "ExitCode": 0, "StartedAt": "2025-06-01T12:00:00.123456789Z", "Health": {"Status": "healthy"}},
+				"Config": {"Image": "linuxserver/syslog-ng:4.10.2"}
+			}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	ctx := context.Background()
+
+	if err := client.ContainerStart(ctx, "nfg-syslog"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := client.ContainerInspect(ctx, "nfg-syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ContainerStop(ctx, "nfg-syslog", 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ContainerRemove(ctx, "nfg-syslog", true); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"POST /containers/nfg-syslog/start?",
+		"GET /containers/nfg-syslog/json?",
+		"POST /containers/nfg-syslog/stop?t=10",
+		"DELETE /containers/nfg-syslog?force=true",
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("got requests %q, want %q", requests, want)
+	}
+
+	if info.ID != "abc123" || !info.State.Running || info.Config.Image != "linuxserver/syslog-ng:4.10.2" {
+		t.Errorf("got %+v", info)
+	}
+	if info.HealthStatus() != "healthy" {
+		t.Errorf("got health %q", info.HealthStatus())
+	}
+	if want := time.Date(2025, 6, 1, 12, 0, 0, 123456789, time.UTC); !info.State.StartedAt.Equal(want) {
+		t.Errorf("got start time %s", info.State.StartedAt)
+	}
+}
+
+func TestContainerInspectNotFound(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"No such container: nfg-logstash"}`))
+	})
+	_, err := client.ContainerInspect(context.Background(), "nfg-logstash")
+	if !IsNotFound(err) {
+		t.Fatalf("expected a 404, got %v", err)
+	}
+}
+
+func TestContainerWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		exitCode int
+		wantErr  bool
+	}{
+		{"exit 0", `{"StatusCode":0}`, 0, false},
+		{"exit 1", `{"StatusCode":1,"Error":null}`, 1, false},
+		{"wait error", `{"StatusCode":137,"Error":{"Message":"container killed"}}`, 137, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.body))
+			})
+			exitCode, err := client.ContainerWait(context.Background(), "abc123")
+			if exitCode != tt.exitCode || (err != nil) != tt.wantErr {
+				t.Errorf("got %d, %v", exitCode, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Exec runs a command in a running container and returns its combined
+// output and exit code
+func (c *Client) Exec(ctx context.Context, container string, cmd []string) (string, int, error) {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	req := map[string]any{
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Cmd":          cmd,
+	}
+	if err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(container)+"/exec", nil, req, &created); err != nil {
+		return "", 0, fmt.Errorf("failed to create exec in container %s: %w", container, err)
+	}
+
+	resp, err := c.request(ctx, http.MethodPost, "/exec/"+url.PathEscape(created.ID)+"/start", nil, map[string]any{"Detach": false, "Tty": false})
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to start exec in container %s: %w", container, err)
+	}
+	var output bytes.Buffer
+	err = demux(resp.Body, &output)
+	resp.Body.Close()
+	if err != nil {
+		return output.String(), 0, fmt.Errorf("failed to read exec output of container %s: %w", container, err)
+	}
+
+	var inspect struct {
+		Running  bool `json:"Running"`
+		ExitCode int  `json:"ExitCode"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/exec/"+url.PathEscape(created.ID)+"/json", nil, nil, &inspect); err != nil {
+		return output.String(), 0, fmt.Errorf("failed to inspect exec in container %s: %w", container, err)
+	}
+	return output.String(), inspect.ExitCode, nil
+}
+
+// demux copies the stdout and stderr frames of a multiplexed stream into w.
+// Each frame has an 8 byte header: the stream type, three zero bytes and the
+// big endian payload size.
+func demux(r io.Reader, w io.Writer) error {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return err
+		}
+	}
+}
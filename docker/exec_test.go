@@ -0,0 +1,94 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// frame returns a frame of a multiplexed stream, 1 is stdout and 2 stderr
+func frame(stream byte, payload string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header, payload...)
+}
+
+func TestExec(t *testing.T) {
+	var cmd []string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /containers/nfg-syslog/exec":
+			var req struct {
+				Cmd []string `json:"Cmd"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			cmd = req.Cmd
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id":"exec1"}`))
+		case "POST /exec/exec1/start":
+			w.Header().Set("Content-Type", "application/vnd.docker.raw-stream")
+			w.Write(frame(1, "syntax "))
+			w.Write(frame(2, "error in line 3\n"))
+			w.Write(frame(1, ""))
+			w.Write(frame(1, "done\n"))
+		case "GET /exec/exec1/json":
+			w.Write([]byte(`{"Running":false,"ExitCode":2}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	output, exitCode, err := client.Exec(context.Background(), "nfg-syslog", []string{"syslog-ng", "--syntax-only"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"syslog-ng", "--syntax-only"}; !reflect.DeepEqual(cmd, want) {
+		t.Errorf("got command %q", cmd)
+	}
+	if output != "syntax error in line 3\ndone\n" {
+		t.Errorf("got output %q", output)
+	}
+	if exitCode != 2 {
+		t.Errorf("got exit code %d", exitCode)
+	}
+}
+
+func TestExecNotRunning(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"message":"container abc123 is not running"}`))
+	})
+	_, _, err := client.Exec(context.Background(), "nfg-syslog", []string{"true"})
+	if err == nil || IsNotFound(err) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+}
+
+func TestDemux(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  []byte
+		output  string
+		wantErr bool
+	}{
+		{"empty", nil, "", false},
+		{"stdout and stderr", append(frame(1, "out"), frame(2, "err")...), "outerr", false},
+		{"truncated header", frame(1, "out")[:4], "", true},
+		{"truncated payload", frame(1, "output")[:10], "ou", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			err := demux(bytes.NewReader(tt.stream), &output)
+			if output.String() != tt.output || (err != nil) != tt.wantErr {
+				t.Errorf("got %q, %v", output.String(), err)
+			}
+		})
+	}
+}
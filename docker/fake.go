@@ -0,0 +1,354 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fake is an in-memory Runtime for tests. Containers never run anything,
+// their exec and exit results come from ExecFunc and WaitFunc. Fake is safe
+// for concurrent use.
+type Fake struct {
+	// ExecFunc answers Exec calls, if nil they succeed without output
+	ExecFunc func(container string, cmd []string) (string, int, error)
+	// WaitFunc returns the output and exit code of a container that runs to
+	// completion, if nil it exits with 0 without output
+	WaitFunc func(spec ContainerSpec) (string, int)
+
+	mu         sync.Mutex
+	nextID     int
+	containers map[string]*fakeContainer // by ID
+	networks   map[string]Network        // by name
+	images     map[string]*ImageInfo     // by reference
+}
+
+type fakeContainer struct {
+	spec   ContainerSpec
+	info   ContainerInfo
+	output string
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		containers: map[string]*fakeContainer{},
+		networks:   map[string]Network{},
+		images:     map[string]*ImageInfo{},
+	}
+}
+
+// AddImage makes an image present locally, as if it was pulled
+func (f *Fake) AddImage(ref string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addImage(ref, true)
+}
+
+// addImage records an image, callers must hold mu. Loaded images have no
+// repo digests, like with a real daemon.
+func (f *Fake) addImage(ref string, pulled bool) {
+	info := &ImageInfo{ID: "sha256:fake-" + ref}
+	if strings.Contains(ref, "@") {
+		if pulled {
+			info.RepoDigests = []string{ref}
+		}
+	} else {
+		info.RepoTags = []string{ref}
+	}
+	f.images[ref] = info
+}
+
+// AddNetwork creates a network
+func (f *Fake) AddNetwork(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.networks[name] = Network{ID: "net-" + name, Name: name}
+}
+
+// Spec returns the spec a container was created with
+func (f *Fake) Spec(name string) (ContainerSpec, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c := f.lookup(name); c != nil {
+		return c.spec, true
+	}
+	return ContainerSpec{}, false
+}
+
+// SetHealth sets the healthcheck status of a container
+func (f *Fake) SetHealth(name string, status string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := f.lookup(name)
+	if c == nil || c.info.State.Health == nil {
+		return fmt.Errorf("container %s has no healthcheck", name)
+	}
+	c.info.State.Health.Status = status
+	return nil
+}
+
+// lookup finds a container by ID or name, callers must hold mu
+func (f *Fake) lookup(id string) *fakeContainer {
+	if c, ok := f.containers[id]; ok {
+		return c
+	}
+	for _, c := range f.containers {
+		if c.spec.Name != "" && c.spec.Name == strings.TrimPrefix(id, "/") {
+			return c
+		}
+	}
+	return nil
+}
+
+func notFound(kind string, id string) error {
+	return &APIError{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("No such %s: %s", kind, id)}
+}
+
+func conflict(format string, args ...any) error {
+	return &APIError{StatusCode: http.StatusConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+func (f *Fake) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (f *Fake) ContainerCreate(ctx context.Context, spec ContainerSpec) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if spec.Name != "" && f.lookup(spec.Name) != nil {
+		return "", conflict("container name %s is already in use", spec.Name)
+	}
+	if f.images[spec.Image] == nil {
+		return "", notFound("image", spec.Image)
+	}
+
+	f.nextID++
+	c := &fakeContainer{spec: spec}
+	c.info.ID = fmt.Sprintf("fake-%d", f.nextID)
+	c.info.Name = "/" + spec.Name
+	c.info.Image = spec.Image
+	c.info.Config.Image = spec.Image
+	c.info.State.Status = "created"
+	f.containers[c.info.ID] = c
+	return c.info.ID, nil
+}
+
+func (f *Fake) ContainerStart(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := f.lookup(id)
+	if c == nil {
+		return notFound("container", id)
+	}
+	c.info.State.Status = "running"
+	c.info.State.Running = true
+	c.info.State.StartedAt = time.Now()
+	if c.spec.Healthcheck != nil {
+		c.info.State.Health = &struct {
+			Status string `json:"Status"`
+		}{Status: "healthy"}
+	}
+	return nil
+}
+
+func (f *Fake) ContainerStop(ctx context.Context, id string, timeout time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := f.lookup(id)
+	if c == nil {
+		return notFound("container", id)
+	}
+	c.info.State.Status = "exited"
+	c.info.State.Running = false
+	return nil
+}
+
+func (f *Fake) ContainerRemove(ctx context.Context, id string, force bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := f.lookup(id)
+	if c == nil {
+		return notFound("container", id)
+	}
+	if c.info.State.Running && !force {
+		return conflict("cannot remove running container %s", id)
+	}
+	delete(f.containers, c.info.ID)
+	return nil
+}
+
+func (f *Fake) ContainerInspect(ctx context.Context, id string) (*ContainerInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := f.lookup(id)
+	if c == nil {
+		return nil, notFound("container", id)
+	}
+	info := c.info
+	if info.State.Health != nil {
+		health := *info.State.Health
+		info.State.Health = &health
+	}
+	return &info, nil
+}
+
+func (f *Fake) ContainerWait(ctx context.Context, id string) (int, error) {
+	f.mu.Lock()
+	c := f.lookup(id)
+	if c == nil {
+		f.mu.Unlock()
+		return 0, notFound("container", id)
+	}
+	spec := c.spec
+	f.mu.Unlock()
+
+	output, exitCode := "", 0
+	if f.WaitFunc != nil {
+		output, exitCode = f.WaitFunc(spec)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c.output = output
+	c.info.State.Status = "exited"
+	c.info.State.Running = false
+	c.info.State.ExitCode = exitCode
+	return exitCode, nil
+}
+
+func (f *Fake) ContainerLogs(ctx context.Context, id string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := f.lookup(id)
+	if c == nil {
+		return "", notFound("container", id)
+	}
+	return c.output, nil
+}
+
+func (f *Fake) Exec(ctx context.Context, container string, cmd []string) (string, int, error) {
+	f.mu.Lock()
+	c := f.lookup(container)
+	running := c != nil && c.info.State.Running
+	f.mu.Unlock()
+
+	if c == nil {
+		return "", 0, notFound("container", container)
+	}
+	if !running {
+		return "", 0, conflict("container %s is not running", container)
+	}
+	if f.ExecFunc == nil {
+		return "", 0, nil
+	}
+	return f.ExecFunc(container, cmd)
+}
+
+func (f *Fake) NetworkList(ctx context.Context) ([]Network, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var networks []Network
+	for _, name := range slices.Sorted(maps.Keys(f.networks)) {
+		networks = append(networks, f.networks[name])
+	}
+	return networks, nil
+}
+
+func (f *Fake) NetworkInspect(ctx context.Context, name string) (*Network, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	network, ok := f.networks[name]
+	if !ok {
+		return nil, notFound("network", name)
+	}
+	return &network, nil
+}
+
+func (f *Fake) NetworkRemove(ctx context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for key, network := range f.networks {
+		if network.Name == name || network.ID == name {
+			delete(f.networks, key)
+			return nil
+		}
+	}
+	return notFound("network", name)
+}
+
+func (f *Fake) ImageInspect(ctx context.Context, ref string) (*ImageInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info := f.images[ref]
+	if info == nil {
+		return nil, notFound("image", ref)
+	}
+	copied := *info
+	return &copied, nil
+}
+
+func (f *Fake) ImagePull(ctx context.Context, ref string, opts PullOptions) error {
+	f.AddImage(ref)
+	if opts.Progress != nil {
+		opts.Progress(PullProgress{Layer: "fake", Status: "Pull complete", Current: 1, Total: 1})
+	}
+	return nil
+}
+
+func (f *Fake) ImageTag(ctx context.Context, ref string, repo string, tag string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info := f.images[ref]
+	if info == nil {
+		return notFound("image", ref)
+	}
+	info.RepoTags = append(info.RepoTags, repo+":"+tag)
+	f.images[repo+":"+tag] = info
+	return nil
+}
+
+// ImageSave writes the references of the images, one per line
+func (f *Fake) ImageSave(ctx context.Context, refs []string, w io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, ref := range refs {
+		if f.images[ref] == nil {
+			return notFound("image", ref)
+		}
+	}
+	_, err := io.WriteString(w, strings.Join(refs, "\n"))
+	return err
+}
+
+// ImageLoad adds the images listed by ImageSave
+func (f *Fake) ImageLoad(ctx context.Context, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, ref := range strings.Fields(string(data)) {
+		f.addImage(ref, false)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ImageInfo is the part of an image inspection the aggregator uses
+type ImageInfo struct {
+	ID          string   `json:"Id"`
+	RepoTags    []string `json:"RepoTags"`
+	RepoDigests []string `json:"RepoDigests"` // empty for images loaded from a tarball
+}
+
+// PullProgress is a progress message of an image pull, per layer
+type PullProgress struct {
+	Layer   string
+	Status  string // e.g. "Downloading", "Pull complete"
+	Current int64  // bytes downloaded or extracted, if known
+	Total   int64
+}
+
+// jsonMessage is a message of the progress streams of pulls and loads
+type jsonMessage struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Stream         string `json:"stream"`
+	Error          string `json:"error"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+}
+
+// readJSONStream passes the messages of a progress stream to fn. The daemon
+// reports failures in the stream, after the 200.
+func readJSONStream(r io.Reader, fn func(jsonMessage)) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var msg jsonMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+		if fn != nil {
+			fn(msg)
+		}
+	}
+}
+
+// ImageInspect returns a local image by reference or ID. A missing image is
+// reported as an error satisfying IsNotFound.
+func (c *Client) ImageInspect(ctx context.Context, ref string) (*ImageInfo, error) {
+	var info ImageInfo
+	if err := c.do(ctx, http.MethodGet, "/images/"+ref+"/json", nil, nil, &info); err != nil {
+		return nil, fmt.Errorf("failed to inspect image %s: %w", ref, err)
+	}
+	return &info, nil
+}
+
+// RegistryAuth are the credentials for a registry
+type RegistryAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// PullOptions configure an image pull
+type PullOptions struct {
+	Registry string        // host of the registry the image is pulled from, sent with the credentials
+	Auth     *RegistryAuth // credentials for the registry, nil for anonymous pulls
+	Progress func(PullProgress)
+}
+
+// ImagePull pulls an image and waits until the pull has finished
+func (c *Client) ImagePull(ctx context.Context, ref string, opts PullOptions) error {
+	var header http.Header
+	if opts.Auth != nil {
+		data, err := json.Marshal(map[string]string{
+			"username":      opts.Auth.Username,
+			"password":      opts.Auth.Password,
+			"serveraddress": opts.Registry,
+		})
+		if err != nil {
+			return err
+		}
+		header = http.Header{"X-Registry-Auth": {base64.URLEncoding.EncodeToString(data)}}
+	}
+
+	resp, err := c.requestWithHeader(ctx, http.MethodPost, "/images/create", url.Values{"fromImage": {ref}}, nil, header)
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", ref, err)
+	}
+	defer resp.Body.Close()
+
+	err = readJSONStream(resp.Body, func(msg jsonMessage) {
+		if opts.Progress != nil && msg.ID != "" {
+			opts.Progress(PullProgress{
+				Layer:   msg.ID,
+				Status:  msg.Status,
+				Current: msg.ProgressDetail.Current,
+				Total:   msg.ProgressDetail.Total,
+			})
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", ref, err)
+	}
+	return nil
+}
+
+// ImageTag adds the reference "repo:tag" to a local image
+func (c *Client) ImageTag(ctx context.Context, ref string, repo string, tag string) error {
+	query := url.Values{"repo": {repo}, "tag": {tag}}
+	if err := c.do(ctx, http.MethodPost, "/images/"+ref+"/tag", query, nil, nil); err != nil {
+		return fmt.Errorf("failed to tag image %s as %s:%s: %w", ref, repo, tag, err)
+	}
+	return nil
+}
+
+// ImageSave writes the given images as a tarball to w, in the format of
+// docker save
+func (c *Client) ImageSave(ctx context.Context, refs []string, w io.Writer) error {
+	resp, err := c.request(ctx, http.MethodGet, "/images/get", url.Values{"names": refs}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to export images %s: %w", strings.Join(refs, ", "), err)
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to export images %s: %w", strings.Join(refs, ", "), err)
+	}
+	return nil
+}
+
+// ImageLoad loads the images of a tarball written by ImageSave or docker save
+func (c *Client) ImageLoad(ctx context.Context, r io.Reader) error {
+	resp, err := c.request(ctx, http.MethodPost, "/images/load", nil, r)
+	if err != nil {
+		return fmt.Errorf("failed to import images: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := readJSONStream(resp.Body, nil); err != nil {
+		return fmt.Errorf("failed to import images: %w", err)
+	}
+	return nil
+}
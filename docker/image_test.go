@@ -0,0 +1,106 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImagePull(t *testing.T) {
+	var auth map[string]string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/images/create" || r.URL.Query().Get("fromImage") != "registry.example.com/syslog-ng:4.10.2" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		data, err := base64.URLEncoding.DecodeString(r.Header.Get("X-Registry-Auth"))
+		if err != nil {
+			t.Error(err)
+		}
+		json.Unmarshal(data, &auth)
+		w.Write([]byte(`{"status":"Pulling from syslog-ng","id":"4.10.2"}
+{"status":"Downloading","progressDetail":{"current":512,"total":2048},"id":"a1b2"}
+{"status":"Download complete","progressDetail":{},"id":"a1b2"}
+{"status":"Status: Downloaded newer image for registry.example.com/syslog-ng:4.10.2"}
+`))
+	})
+
+	var progress []PullProgress
+	err := client.ImagePull(context.Background(), "registry.example.com/syslog-ng:4.10.2", PullOptions{
+		Registry: "registry.example.com",
+		Auth:     &RegistryAuth{Username: "nfg", Password: "s3cret"},
+		Progress: func(p PullProgress) { progress = append(progress, p) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantAuth := map[string]string{"username": "nfg", "password": "s3cret", "serveraddress": "registry.example.com"}
+	if !reflect.DeepEqual(auth, wantAuth) {
+		t.Errorf("got auth %v", auth)
+	}
+	wantProgress := []PullProgress{
+		{Layer: "4.10.2", Status: "Pulling from syslog-ng"},
+		{Layer: "a1b2", Status: "Downloading", Current: 512, Total: 2048},
+		{Layer: "a1b2", Status: "Download complete"},
+	}
+	if !reflect.DeepEqual(progress, wantProgress) {
+		t.Errorf("got progress %+v", progress)
+	}
+}
+
+func TestImagePullStreamError(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Registry-Auth") != "" {
+			t.Error("anonymous pulls send no credentials")
+		}
+		// the daemon has already answered 200 when the registry fails
+		w.Write([]byte(`{"status":"Pulling from library/logstash","id":"8.19.2"}
+{"errorDetail":{"message":"manifest unknown"},"error":"manifest unknown"}
+`))
+	})
+
+	err := client.ImagePull(context.Background(), "logstash:8.19.2", PullOptions{})
+	if err == nil || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Fatalf("expected the stream error, got %v", err)
+	}
+	if IsNotFound(err) {
+		t.Error("stream errors are no API errors")
+	}
+}
+
+func TestImageInspectNotFound(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/images/linuxserver/syslog-ng:4.10.2/json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"No such image: linuxserver/syslog-ng:4.10.2"}`))
+	})
+	_, err := client.ImageInspect(context.Background(), "linuxserver/syslog-ng:4.10.2")
+	if !IsNotFound(err) {
+		t.Fatalf("expected a 404, got %v", err)
+	}
+}
+
+func TestImageLoad(t *testing.T) {
+	var body []byte
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/x-tar" {
+			t.Errorf("got content type %q", ct)
+		}
+		body, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{"stream":"Loaded image: logstash:8.19.2\n"}` + "\n"))
+	})
+	if err := client.ImageLoad(context.Background(), bytes.NewReader([]byte("tarball"))); err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "tarball" {
+		t.Errorf("got body %q", body)
+	}
+}
@@ -0,0 +1,40 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+type Network struct {
+	ID   string `json:"Id"`
+	Name string `json:"Name"`
+}
+
+// NetworkList returns all networks
+func (c *Client) NetworkList(ctx context.Context) ([]Network, error) {
+	var networks []Network
+	if err := c.do(ctx, http.MethodGet, "/networks", nil, nil, &networks); err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+	return networks, nil
+}
+
+// NetworkInspect returns a network by name or ID. A missing network is
+// reported as an error satisfying IsNotFound.
+func (c *Client) NetworkInspect(ctx context.Context, name string) (*Network, error) {
+	var network Network
+	if err := c.do(ctx, http.MethodGet, "/networks/"+url.PathEscape(name), nil, nil, &network); err != nil {
+		return nil, fmt.Errorf("failed to inspect network %s: %w", name, err)
+	}
+	return &network, nil
+}
+
+// NetworkRemove removes a network by name or ID
+func (c *Client) NetworkRemove(ctx context.Context, name string) error {
+	if err := c.do(ctx, http.MethodDelete, "/networks/"+url.PathEscape(name), nil, nil, nil); err != nil {
+		return fmt.Errorf("failed to remove network %s: %w", name, err)
+	}
+	return nil
+}
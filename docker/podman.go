@@ -0,0 +1,74 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+)
+
+// Podman is a Runtime for the Docker compatible API of Podman. Podman may
+// refuse or prompt for short image names depending on its registries.conf,
+// so images are always referenced by their fully qualified name.
+type Podman struct {
+	*Client
+}
+
+// NewPodman returns a runtime for the Podman service listening on the given
+// Unix socket
+func NewPodman(socket string) *Podman {
+	return &Podman{Client: NewClient(socket)}
+}
+
+// PodmanFromEnv returns a runtime for the Podman service in CONTAINER_HOST,
+// or the rootful socket for root and the rootless one for other users
+func PodmanFromEnv() (*Podman, error) {
+	host := os.Getenv("CONTAINER_HOST")
+	if host == "" {
+		return NewPodman(defaultPodmanSocket()), nil
+	}
+	socket, err := socketFromHost("CONTAINER_HOST", host)
+	if err != nil {
+		return nil, err
+	}
+	return NewPodman(socket), nil
+}
+
+func (p *Podman) ContainerCreate(ctx context.Context, spec ContainerSpec) (string, error) {
+	spec.Image = qualifyImage(spec.Image)
+	return p.Client.ContainerCreate(ctx, spec)
+}
+
+func (p *Podman) ImageInspect(ctx context.Context, ref string) (*ImageInfo, error) {
+	return p.Client.ImageInspect(ctx, qualifyImage(ref))
+}
+
+func (p *Podman) ImagePull(ctx context.Context, ref string, opts PullOptions) error {
+	return p.Client.ImagePull(ctx, qualifyImage(ref), opts)
+}
+
+func (p *Podman) ImageTag(ctx context.Context, ref string, repo string, tag string) error {
+	return p.Client.ImageTag(ctx, qualifyImage(ref), qualifyImage(repo), tag)
+}
+
+func (p *Podman) ImageSave(ctx context.Context, refs []string, w io.Writer) error {
+	qualified := make([]string, len(refs))
+	for i, ref := range refs {
+		qualified[i] = qualifyImage(ref)
+	}
+	return p.Client.ImageSave(ctx, qualified, w)
+}
+
+// qualifyImage prefixes short image names the way Docker resolves them,
+// e.g. "linuxserver/syslog-ng:4.10.2" becomes
+// "docker.io/linuxserver/syslog-ng:4.10.2"
+func qualifyImage(ref string) string {
+	first, _, found := strings.Cut(ref, "/")
+	if !found {
+		return "docker.io/library/" + ref
+	}
+	if strings.ContainsAny(first, ".:") || first == "localhost" {
+		return ref
+	}
+	return "docker.io/" + ref
+}
@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Runtime is a container engine the aggregator runs its services on
+type Runtime interface {
+	Ping(ctx context.Context) error
+
+	ContainerCreate(ctx context.Context, spec ContainerSpec) (string, error)
+	ContainerStart(ctx context.Context, id string) error
+	ContainerStop(ctx context.Context, id string, timeout time.Duration) error
+	ContainerRemove(ctx context.Context, id string, force bool) error
+	ContainerInspect(ctx context.Context, id string) (*ContainerInfo, error)
+	ContainerWait(ctx context.Context, id string) (int, error)
+	ContainerLogs(ctx context.Context, id string) (string, error)
+	Exec(ctx context.Context, container string, cmd []string) (string, int, error)
+
+	NetworkList(ctx context.Context) ([]Network, error)
+	NetworkInspect(ctx context.Context, name string) (*Network, error)
+	NetworkRemove(ctx context.Context, name string) error
+
+	ImageInspect(ctx context.Context, ref string) (*ImageInfo, error)
+	ImagePull(ctx context.Context, ref string, opts PullOptions) error
+	ImageTag(ctx context.Context, ref string, repo string, tag string) error
+	ImageSave(ctx context.Context, refs []string, w io.Writer) error
+	ImageLoad(ctx context.Context, r io.Reader) error
+}
+
+var (
+	_ Runtime = (*Client)(nil)
+	_ Runtime = (*Podman)(nil)
+	_ Runtime = (*Fake)(nil)
+)
+
+// Supported runtimes
+const (
+	RuntimeDocker = "docker"
+	RuntimePodman = "podman"
+)
+
+// RuntimeFromEnv returns the named runtime, connected to the socket in its
+// usual environment variable: DOCKER_HOST for Docker, CONTAINER_HOST for
+// Podman
+func RuntimeFromEnv(name string) (Runtime, error) {
+	switch name {
+	case RuntimeDocker:
+		return FromEnv()
+	case RuntimePodman:
+		return PodmanFromEnv()
+	default:
+		return nil, fmt.Errorf("unsupported container runtime %q", name)
+	}
+}
+
+// Run creates and starts a container, waits for it to exit and returns its
+// combined output and exit code. The container is removed afterwards, even
+// if the context expires.
+func Run(ctx context.Context, rt Runtime, spec ContainerSpec) (string, int, error) {
+	id, err := rt.ContainerCreate(ctx, spec)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		removeCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		rt.ContainerRemove(removeCtx, id, true)
+	}()
+
+	if err := rt.ContainerStart(ctx, id); err != nil {
+		return "", 0, err
+	}
+	exitCode, err := rt.ContainerWait(ctx, id)
+	if err != nil {
+		return "", 0, err
+	}
+	output, err := rt.ContainerLogs(ctx, id)
+	return output, exitCode, err
+}
+
+// socketFromHost returns the socket path of a unix:// host
+func socketFromHost(variable string, host string) (string, error) {
+	socket, found := strings.CutPrefix(host, "unix://")
+	if !found || socket == "" {
+		return "", fmt.Errorf("unsupported %s %q, only unix:// sockets are supported", variable, host)
+	}
+	return socket, nil
+}
+
+// defaultPodmanSocket is the system socket for root, the user socket otherwise
+func defaultPodmanSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" && os.Geteuid() != 0 {
+		return dir + "/podman/podman.sock"
+	}
+	return "/run/podman/podman.sock"
+}
@@ -0,0 +1,298 @@
+// Package logstash builds Logstash pipeline configs from a small syntax tree,
+// so values are always quoted and escaped instead of interpolated into the DSL.
+//
+// Strings are rendered with backslash escapes, which requires
+// config.support_escapes: true in logstash.yml. Logstash substitutes ${VAR}
+// anywhere in a string and offers no way to escape it, so strings containing
+// "${" are rejected; use Env to reference an environment variable on purpose.
+package logstash
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Value is a setting value: String, Env, Int, Bool, Array or Hash
+type Value interface {
+	render(b *strings.Builder, indent int) error
+}
+
+// String is a double-quoted string literal
+type String string
+
+// Env references an environment variable of the logstash container, ${NAME}
+type Env string
+
+// Int is a number literal
+type Int int
+
+// Bool is a boolean literal
+type Bool bool
+
+// Array is a list of values, [a, b]
+type Array []Value
+
+// Hash is an ordered list of key/value pairs, { "k" => v }
+type Hash []HashEntry
+
+type HashEntry struct {
+	Key   string
+	Value Value
+}
+
+// Setting is one "name => value" line of a plugin
+type Setting struct {
+	Name  string
+	Value Value
+}
+
+// Plugin is a plugin block such as elasticsearch { ... }
+type Plugin struct {
+	Name     string
+	Settings []Setting
+}
+
+// Block is an entry of a section: a Plugin or an If
+type Block interface {
+	renderBlock(b *strings.Builder, indent int) error
+}
+
+// If runs Then only for events matching Condition
+type If struct {
+	Condition Condition
+	Then      []Block
+}
+
+// Condition is the expression of an If
+type Condition interface {
+	renderCondition(b *strings.Builder) error
+}
+
+// FieldRef references an event field by its path, [a][b]. As a condition it
+// matches events where the field is set.
+type FieldRef []string
+
+// NotIn matches events whose field is none of Values
+type NotIn struct {
+	Field  FieldRef
+	Values []string
+}
+
+// Section is a top level input, filter or output block
+type Section struct {
+	Type   string
+	Blocks []Block
+}
+
+// Config is a complete pipeline config
+type Config struct {
+	Sections []Section
+}
+
+var (
+	identPattern     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	envNamePattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	fieldNamePattern = regexp.MustCompile(`^[A-Za-z0-9_@.-]+$`)
+)
+
+// Render returns the pipeline config in Logstash syntax
+func (c Config) Render() (string, error) {
+	var b strings.Builder
+	for i, s := range c.Sections {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if err := s.render(&b); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+func (s Section) render(b *strings.Builder) error {
+	switch s.Type {
+	case "input", "filter", "output":
+	default:
+		return fmt.Errorf("invalid section type %q", s.Type)
+	}
+
+	b.WriteString(s.Type + " {\n")
+	if err := renderBlocks(b, s.Blocks, 1); err != nil {
+		return err
+	}
+	b.WriteString("}\n")
+	return nil
+}
+
+func renderBlocks(b *strings.Builder, blocks []Block, indent int) error {
+	for _, block := range blocks {
+		if block == nil {
+			return fmt.Errorf("empty block")
+		}
+		if err := block.renderBlock(b, indent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i If) renderBlock(b *strings.Builder, indent int) error {
+	if i.Condition == nil {
+		return fmt.Errorf("if without condition")
+	}
+
+	tabs := strings.Repeat("\t", indent)
+	b.WriteString(tabs + "if ")
+	if err := i.Condition.renderCondition(b); err != nil {
+		return err
+	}
+	b.WriteString(" {\n")
+	if err := renderBlocks(b, i.Then, indent+1); err != nil {
+		return err
+	}
+	b.WriteString(tabs + "}\n")
+	return nil
+}
+
+func (f FieldRef) renderCondition(b *strings.Builder) error {
+	if len(f) == 0 {
+		return fmt.Errorf("empty field reference")
+	}
+	for _, name := range f {
+		if !fieldNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid field name %q", name)
+		}
+		b.WriteString("[" + name + "]")
+	}
+	return nil
+}
+
+func (n NotIn) renderCondition(b *strings.Builder) error {
+	if len(n.Values) == 0 {
+		return fmt.Errorf("not in without values")
+	}
+	if err := n.Field.renderCondition(b); err != nil {
+		return err
+	}
+	// Logstash treats a one element list as a string and matches substrings
+	if len(n.Values) == 1 {
+		b.WriteString(" != ")
+		return String(n.Values[0]).render(b, 0)
+	}
+	b.WriteString(" not in ")
+	values := make(Array, len(n.Values))
+	for i, v := range n.Values {
+		values[i] = String(v)
+	}
+	return values.render(b, 0)
+}
+
+func (p Plugin) renderBlock(b *strings.Builder, indent int) error {
+	if !identPattern.MatchString(p.Name) {
+		return fmt.Errorf("invalid plugin name %q", p.Name)
+	}
+
+	tabs := strings.Repeat("\t", indent)
+	b.WriteString(tabs + p.Name + " {\n")
+	for _, s := range p.Settings {
+		if !identPattern.MatchString(s.Name) {
+			return fmt.Errorf("%s: invalid setting name %q", p.Name, s.Name)
+		}
+		if s.Value == nil {
+			return fmt.Errorf("%s: setting %s has no value", p.Name, s.Name)
+		}
+		b.WriteString(tabs + "\t" + s.Name + " => ")
+		if err := s.Value.render(b, indent+1); err != nil {
+			return fmt.Errorf("%s: setting %s: %w", p.Name, s.Name, err)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(tabs + "}\n")
+	return nil
+}
+
+func (s String) render(b *strings.Builder, _ int) error {
+	q, err := quote(string(s))
+	if err != nil {
+		return err
+	}
+	b.WriteString(q)
+	return nil
+}
+
+func (e Env) render(b *strings.Builder, _ int) error {
+	if !envNamePattern.MatchString(string(e)) {
+		return fmt.Errorf("invalid environment variable name %q", string(e))
+	}
+	b.WriteString(`"${` + string(e) + `}"`)
+	return nil
+}
+
+func (i Int) render(b *strings.Builder, _ int) error {
+	b.WriteString(strconv.Itoa(int(i)))
+	return nil
+}
+
+func (v Bool) render(b *strings.Builder, _ int) error {
+	b.WriteString(strconv.FormatBool(bool(v)))
+	return nil
+}
+
+func (a Array) render(b *strings.Builder, indent int) error {
+	b.WriteString("[")
+	for i, v := range a {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if v == nil {
+			return fmt.Errorf("array element %d has no value", i)
+		}
+		if err := v.render(b, indent); err != nil {
+			return err
+		}
+	}
+	b.WriteString("]")
+	return nil
+}
+
+func (h Hash) render(b *strings.Builder, indent int) error {
+	tabs := strings.Repeat("\t", indent)
+	b.WriteString("{\n")
+	for _, e := range h {
+		key, err := quote(e.Key)
+		if err != nil {
+			return fmt.Errorf("hash key: %w", err)
+		}
+		if e.Value == nil {
+			return fmt.Errorf("hash key %s has no value", key)
+		}
+		b.WriteString(tabs + "\t" + key + " => ")
+		if err := e.Value.render(b, indent+1); err != nil {
+			return fmt.Errorf("hash key %s: %w", key, err)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(tabs + "}")
+	return nil
+}
+
+var escaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+)
+
+// quote renders s as a double-quoted Logstash string
+func quote(s string) (string, error) {
+	if strings.Contains(s, "${") {
+		return "", fmt.Errorf("string %q contains an environment variable reference", s)
+	}
+	if strings.ContainsRune(s, 0) {
+		return "", fmt.Errorf("string %q contains a NUL byte", s)
+	}
+	return `"` + escaper.Replace(s) + `"`, nil
+}
@@ -0,0 +1,218 @@
+package logstash
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"plain", "logstash-*", `"logstash-*"`, false},
+		{"empty", "", `""`, false},
+		{"double quote", `pa"ss`, `"pa\"ss"`, false},
+		{"backslash", `C:\logs\`, `"C:\\logs\\"`, false},
+		{"escaped quote", `\"`, `"\\\""`, false},
+		{"dollar", "pa$$word$", `"pa$$word$"`, false},
+		{"dollar brace", "$ {HOME} ${", "", true},
+		{"env reference", "${AUTH_KEY}", "", true},
+		{"newline", "a\nb", `"a\nb"`, false},
+		{"carriage return and tab", "a\r\tb", `"a\r\tb"`, false},
+		{"nul", "a\x00b", "", true},
+		{"unicode", "honeypot-ü", `"honeypot-ü"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := quote(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	conf := Config{Sections: []Section{
+		{Type: "input", Blocks: []Block{
+			Plugin{Name: "elasticsearch", Settings: []Setting{
+				{Name: "hosts", Value: String("https://es:9200")},
+				{Name: "password", Value: Env("ES_PASSWORD_0")},
+				{Name: "size", Value: Int(1000)},
+				{Name: "docinfo", Value: Bool(true)},
+				{Name: "ssl_certificate_authorities", Value: Array{String("/certs/ca.pem")}},
+			}},
+		}},
+		{Type: "filter", Blocks: []Block{
+			If{
+				Condition: NotIn{Field: FieldRef{"type"}, Values: []string{"Cowrie"}},
+				Then:      []Block{Plugin{Name: "drop"}},
+			},
+			If{
+				Condition: NotIn{Field: FieldRef{"[meta]", "type"}, Values: []string{"Cowrie"}},
+				Then:      []Block{Plugin{Name: "drop"}},
+			},
+		}},
+	}}
+	if _, err := conf.Render(); err == nil {
+		t.Fatal("expected the invalid field name to be rejected")
+	}
+
+	conf.Sections[1].Blocks = conf.Sections[1].Blocks[:1]
+	conf.Sections = append(conf.Sections, Section{Type: "output", Blocks: []Block{
+		Plugin{Name: "http", Settings: []Setting{
+			{Name: "headers", Value: Hash{
+				{Key: "X-AUTH_KEY", Value: Env("AUTH_KEY")},
+				{Key: `X-"NAME"`, Value: String(`tpot "01"`)},
+				{Key: "nested", Value: Hash{
+					{Key: "list", Value: Array{Int(1), Array{String("a"), Bool(false)}}},
+				}},
+			}},
+		}},
+	}})
+	got, err := conf.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `input {
+	elasticsearch {
+		hosts => "https://es:9200"
+		password => "${ES_PASSWORD_0}"
+		size => 1000
+		docinfo => true
+		ssl_certificate_authorities => ["/certs/ca.pem"]
+	}
+}
+
+filter {
+	if [type] != "Cowrie" {
+		drop {
+		}
+	}
+}
+
+output {
+	http {
+		headers => {
+			"X-AUTH_KEY" => "${AUTH_KEY}"
+			"X-\"NAME\"" => "tpot \"01\""
+			"nested" => {
+				"list" => [1, ["a", false]]
+			}
+		}
+	}
+}
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestNotIn(t *testing.T) {
+	tests := []struct {
+		name    string
+		cond    NotIn
+		want    string
+		wantErr bool
+	}{
+		// a one element list would be matched as a substring of a string
+		{"one value", NotIn{Field: FieldRef{"type"}, Values: []string{"Cowrie"}}, `[type] != "Cowrie"`, false},
+		{"several values", NotIn{Field: FieldRef{"type"}, Values: []string{"Cowrie", "Dionaea"}}, `[type] not in ["Cowrie", "Dionaea"]`, false},
+		{"nested field", NotIn{Field: FieldRef{"geoip", "country_code2"}, Values: []string{"DE", "AT"}}, `[geoip][country_code2] not in ["DE", "AT"]`, false},
+		{"escaped value", NotIn{Field: FieldRef{"type"}, Values: []string{`a"b`}}, `[type] != "a\"b"`, false},
+		{"no values", NotIn{Field: FieldRef{"type"}}, "", true},
+		{"no field", NotIn{Values: []string{"Cowrie"}}, "", true},
+		{"env reference", NotIn{Field: FieldRef{"type"}, Values: []string{"${AUTH_KEY}"}}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			err := tt.cond.renderCondition(&b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v", err)
+			}
+			if !tt.wantErr && b.String() != tt.want {
+				t.Errorf("got %s, want %s", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		block Block
+	}{
+		{"plugin with space", Plugin{Name: "http }"}},
+		{"plugin with brace", Plugin{Name: "drop{"}},
+		{"plugin starting with digit", Plugin{Name: "1http"}},
+		{"empty plugin", Plugin{}},
+		{"setting with arrow", Plugin{Name: "http", Settings: []Setting{{Name: "url =>", Value: String("x")}}}},
+		{"setting with quote", Plugin{Name: "http", Settings: []Setting{{Name: `url"`, Value: String("x")}}}},
+		{"setting without value", Plugin{Name: "http", Settings: []Setting{{Name: "url"}}}},
+		{"field with bracket", If{Condition: FieldRef{"type]"}, Then: []Block{Plugin{Name: "drop"}}}},
+		{"field with space", If{Condition: FieldRef{"event type"}, Then: []Block{Plugin{Name: "drop"}}}},
+		{"empty field", If{Condition: FieldRef{""}, Then: []Block{Plugin{Name: "drop"}}}},
+		{"if without condition", If{Then: []Block{Plugin{Name: "drop"}}}},
+		{"nil block", If{Condition: FieldRef{"type"}, Then: []Block{nil}}},
+		{"nil array element", Plugin{Name: "http", Settings: []Setting{{Name: "hosts", Value: Array{nil}}}}},
+		{"nil hash value", Plugin{Name: "http", Settings: []Setting{{Name: "headers", Value: Hash{{Key: "a"}}}}}},
+		{"env reference in hash key", Plugin{Name: "http", Settings: []Setting{{Name: "headers", Value: Hash{{Key: "${A}", Value: String("x")}}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Config{Sections: []Section{{Type: "filter", Blocks: []Block{tt.block}}}}
+			if got, err := conf.Render(); err == nil {
+				t.Errorf("expected an error, got:\n%s", got)
+			}
+		})
+	}
+
+	if _, err := (Config{Sections: []Section{{Type: "codec"}}}).Render(); err == nil {
+		t.Error("expected the invalid section type to be rejected")
+	}
+}
+
+func TestEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     Env
+		wantErr bool
+	}{
+		{"upper case", "ES_PASSWORD_0", false},
+		{"leading underscore", "_SECRET", false},
+		{"lower case", "auth_key", false},
+		{"empty", "", true},
+		{"leading digit", "0_KEY", true},
+		{"brace", "KEY}", true},
+		{"default value", "KEY:default", true},
+		{"nested reference", "${KEY}", true},
+		{"dash", "AUTH-KEY", true},
+		{"quote", `KEY"`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			err := tt.env.render(&b, 0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v", err)
+			}
+			if !tt.wantErr && b.String() != `"${`+string(tt.env)+`}"` {
+				t.Errorf("got %s", b.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package models
+
+// LogstashSettings tunes the Logstash pipeline, its persistent queue and the
+// output towards the collector. Zero values fall back to the aggregator defaults.
+type LogstashSettings struct {
+	PipelineWorkers      int `json:"pipelineWorkers,omitempty"`
+	BatchSize            int `json:"batchSize,omitempty"`
+	QueueMaxMB           int `json:"queueMaxMB,omitempty"`
+	DLQMaxMB             int `json:"dlqMaxMB,omitempty"`
+	OutputRetries        int `json:"outputRetries,omitempty"`
+	OutputPoolMax        int `json:"outputPoolMax,omitempty"`
+	OutputTimeoutSeconds int `json:"outputTimeoutSeconds,omitempty"`
+}
+
+// LogstashFilter selects what the T-Pot pipeline forwards to the collector.
+// Empty lists forward everything.
+type LogstashFilter struct {
+	HoneypotTypes []string `json:"honeypotTypes,omitempty"` // values of the T-Pot "type" field, e.g. "Cowrie"
+	IncludeFields []string `json:"includeFields,omitempty"` // top level fields to keep, all others are removed
+	ExcludeFields []string `json:"excludeFields,omitempty"` // top level fields to remove
+}
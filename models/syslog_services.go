@@ -6,3 +6,71 @@ type SyslogServices struct {
 	SyslogOpnsenseEnabled bool `json:"syslogOpnsenseEnabled"`
 	SyslogSuricataEnabled bool `json:"syslogSuricataEnabled"`
 }
+
+// SyslogPorts holds the UDP listener port per syslog service.
+// A zero value means "not set" and falls back to the next source.
+type SyslogPorts struct {
+	SyslogCiscoFtdPort int `json:"syslogCiscoFtdPort,omitempty"`
+	SyslogCiscoIsePort int `json:"syslogCiscoIsePort,omitempty"`
+	SyslogOpnsensePort int `json:"syslogOpnsensePort,omitempty"`
+	SyslogSuricataPort int `json:"syslogSuricataPort,omitempty"`
+}
+
+// SyslogAllowlists holds the sender CIDRs allowed per syslog service.
+// An empty list accepts messages from any sender.
+type SyslogAllowlists struct {
+	SyslogCiscoFtdAllowlist []string `json:"syslogCiscoFtdAllowlist,omitempty"`
+	SyslogCiscoIseAllowlist []string `json:"syslogCiscoIseAllowlist,omitempty"`
+	SyslogOpnsenseAllowlist []string `json:"syslogOpnsenseAllowlist,omitempty"`
+	SyslogSuricataAllowlist []string `json:"syslogSuricataAllowlist,omitempty"`
+}
+
+// SyslogDelivery controls how syslog-ng batches messages towards the collector.
+// Zero values fall back to the aggregator defaults.
+type SyslogDelivery struct {
+	BatchLines     int    `json:"batchLines,omitempty"`
+	BatchBytes     int    `json:"batchBytes,omitempty"`
+	BatchTimeoutMs int    `json:"batchTimeoutMs,omitempty"`
+	Framing        string `json:"framing,omitempty"`     // "newline" or "json-array"
+	Compression    string `json:"compression,omitempty"` // "" or "gzip"
+	TimeoutSeconds int    `json:"timeoutSeconds,omitempty"`
+}
+
+// SyslogMessageFilter selects which messages of a service are forwarded.
+// If Include is set, only matching messages are kept; matches of Exclude are
+// always dropped. Values are service specific: FTD message IDs, ISE message
+// codes, OPNsense program names or Suricata event types.
+type SyslogMessageFilter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// SyslogFilters holds the message filter per syslog service
+type SyslogFilters struct {
+	SyslogCiscoFtdFilter SyslogMessageFilter `json:"syslogCiscoFtdFilter"`
+	SyslogCiscoIseFilter SyslogMessageFilter `json:"syslogCiscoIseFilter"`
+	SyslogOpnsenseFilter SyslogMessageFilter `json:"syslogOpnsenseFilter"`
+	SyslogSuricataFilter SyslogMessageFilter `json:"syslogSuricataFilter"`
+}
+
+// SyslogBodyFormats selects the HTTP body format per syslog service,
+// "text" (default) for the syslog line or "json" for a structured object.
+type SyslogBodyFormats struct {
+	SyslogCiscoFtdBodyFormat string `json:"syslogCiscoFtdBodyFormat,omitempty"`
+	SyslogCiscoIseBodyFormat string `json:"syslogCiscoIseBodyFormat,omitempty"`
+	SyslogOpnsenseBodyFormat string `json:"syslogOpnsenseBodyFormat,omitempty"`
+	SyslogSuricataBodyFormat string `json:"syslogSuricataBodyFormat,omitempty"`
+}
+
+// SyslogInstance is a named syslog source of a given service type. Several
+// instances of the same type can run side by side, e.g. one per FTD cluster.
+type SyslogInstance struct {
+	Name       string              `json:"name"`
+	Type       string              `json:"type"` // "firepower", "ise", "opnsense" or "suricata"
+	Port       int                 `json:"port"`
+	Tag        string              `json:"tag,omitempty"`
+	Headers    map[string]string   `json:"headers,omitempty"`
+	Allowlist  []string            `json:"allowlist,omitempty"`
+	Filter     SyslogMessageFilter `json:"filter,omitempty"`
+	BodyFormat string              `json:"bodyFormat,omitempty"`
+}
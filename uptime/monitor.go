@@ -1,54 +1,23 @@
 package uptime
 
 import (
-	"os/exec"
-	"strings"
+	"fmt"
+	"time"
 
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/config"
 	"go.uber.org/zap"
 )
 
-func dockerPs(name string) string {
-	out, _ := exec.Command("docker", "ps", "-f", "name="+name, "--format", "{{.Status}}").Output()
-	return strings.TrimSpace(string(out))
-}
-
-func isLogstashContainerHealthy(name string) bool {
-	out, err := exec.Command("docker", "logs", "--tail", "100", name).Output()
-	if err != nil {
-		zap.L().Error("Failed to get docker logs for health check", zap.String("container", name), zap.Error(err))
-		return false
-	}
-
-	logs := string(out)
-	zap.L().Debug("Recent container logs for health check",
-		zap.String("container", name),
-		zap.String("logs", logs),
-	)
-
-	// Check for Elasticsearch UnknownHostException in logs
-	if strings.Contains(logs, "UnknownHostException") {
-		zap.L().Warn("Detected Elasticsearch connectivity issues in container logs", zap.String("container", name))
-		return false
-	}
-
-	return true
-}
-
-func isContainerRunning(name string) bool {
-	status := dockerPs(name)
-	return strings.HasPrefix(strings.ToLower(status), "up")
-}
-
-func MonitorServices(runSyslog bool, runLogstash bool) (bool, bool, bool) {
-	var syslogRunning, logstashRunning, logstashHealthy bool
+func MonitorServices(runSyslog bool, runLogstash bool) (bool, bool) {
+	var syslogRunning, logstashRunning bool
 
 	if !runSyslog && !runLogstash {
 		zap.L().Info("No services enabled to monitor.") // all good, nothing needs to run
-		return true, true, true
+		return true, true
 	}
 
 	if runSyslog {
-		syslogRunning = isContainerRunning("nfg-syslog")
+		syslogRunning = config.ContainerRunning("nfg-syslog")
 		if syslogRunning {
 			zap.L().Info("Syslog container is running")
 		} else {
@@ -57,20 +26,163 @@ func MonitorServices(runSyslog bool, runLogstash bool) (bool, bool, bool) {
 	}
 
 	if runLogstash {
-		logstashRunning = isContainerRunning("nfg-logstash")
+		logstashRunning = config.ContainerRunning("nfg-logstash")
 		if logstashRunning {
 			zap.L().Info("Logstash container is running")
 		} else {
 			zap.L().Warn("Logstash container is not running")
 		}
+	}
 
-		logstashHealthy = isLogstashContainerHealthy("nfg-logstash")
-		if logstashHealthy {
-			zap.L().Info("Logstash container is healthy")
-		} else {
-			zap.L().Warn("Logstash container is unhealthy")
+	return syslogRunning, logstashRunning
+}
+
+// logstashHealth is the verdict on a running logstash. Reasons explain why it
+// is unhealthy, needsRestart is set if restarting the container may help.
+type logstashHealth struct {
+	healthy      bool
+	needsRestart bool
+	reasons      []string
+}
+
+func (h *logstashHealth) fail(restart bool, format string, args ...any) {
+	h.healthy = false
+	h.needsRestart = h.needsRestart || restart
+	h.reasons = append(h.reasons, fmt.Sprintf(format, args...))
+}
+
+// logstash may take this long after a start until its monitoring API answers
+const logstashStartGrace = 3 * time.Minute
+
+// share of the persistent queue above which logstash is reported unhealthy
+const logstashQueueFullRatio = 0.9
+
+// share of a syslog disk buffer above which a warning is logged
+const syslogBufferFullRatio = 0.9
+
+// stats of the previous check, to tell stalled pipelines from idle ones
+var prevLogstashStats *config.LogstashPipelineStats
+
+// checkLogstashHealth rates the running logstash container from its node
+// stats and records its queue in the status
+func checkLogstashHealth(s *Status) logstashHealth {
+	h := logstashHealth{healthy: true}
+
+	stats, err := config.LogstashNodeStats()
+	if err != nil {
+		prevLogstashStats = nil
+		startedAt, serr := config.ContainerStartedAt("nfg-logstash")
+		if serr == nil && time.Since(startedAt) < logstashStartGrace {
+			h.fail(false, "logstash is starting")
+			return h
 		}
+		h.fail(true, "monitoring API unreachable: %v", err)
+		return h
 	}
 
-	return syslogRunning, logstashRunning, logstashHealthy
+	pipeline, ok := stats.Pipelines["main"]
+	if !ok {
+		prevLogstashStats = nil
+		h.fail(true, "pipeline main is not running")
+		return h
+	}
+
+	s.LogstashQueued = pipeline.Queue.EventsCount
+	s.LogstashDLQBytes = pipeline.DeadLetterQueue.QueueSizeInBytes
+
+	r := pipeline.Reloads
+	if r.LastError != nil && r.LastFailureTimestamp != nil &&
+		(r.LastSuccessTimestamp == nil || r.LastFailureTimestamp.After(*r.LastSuccessTimestamp)) {
+		h.fail(false, "pipeline reload failed: %s", r.LastError.Message)
+	}
+
+	q := pipeline.Queue
+	if q.MaxQueueSizeInBytes > 0 && float64(q.QueueSizeInBytes) >= logstashQueueFullRatio*float64(q.MaxQueueSizeInBytes) {
+		h.fail(false, "persistent queue is %d%% full", q.QueueSizeInBytes*100/q.MaxQueueSizeInBytes)
+	}
+
+	if prev := prevLogstashStats; prev != nil {
+		outputFailures := false
+		for _, out := range pipeline.Plugins.Outputs {
+			for _, p := range prev.Plugins.Outputs {
+				if p.ID == out.ID && out.Failures > p.Failures {
+					outputFailures = true
+					h.fail(false, "output %s failed %d times since last check", out.Name, out.Failures-p.Failures)
+				}
+			}
+		}
+
+		// events waiting while nothing leaves the pipeline, and the outputs
+		// don't report errors: the workers are stuck
+		if q.EventsCount > 0 && pipeline.Events.Out == prev.Events.Out && !outputFailures {
+			h.fail(true, "pipeline stuck with %d queued events", q.EventsCount)
+		}
+	}
+	prevLogstashStats = &pipeline
+
+	return h
+}
+
+// reads the syslog-ng counters into the status and logs dropped and queued messages
+func collectSyslogStats(cfg *config.Config, s *Status) {
+	rejected, err := config.SyslogRejectedCounts()
+	if err != nil {
+		zap.L().Debug("Failed to read syslog-ng stats", zap.Error(err))
+		return
+	}
+	s.SyslogRejected = rejected
+	for service, count := range rejected {
+		if count > 0 {
+			zap.L().Warn("Dropped syslog messages from senders outside the allowlist",
+				zap.String("service", service),
+				zap.Int64("rejected", count),
+			)
+		}
+	}
+
+	filtered, err := config.SyslogFilteredCounts()
+	if err != nil {
+		zap.L().Debug("Failed to read syslog-ng filter stats", zap.Error(err))
+		return
+	}
+	s.SyslogFiltered = filtered
+	for service, count := range filtered {
+		if count > 0 {
+			zap.L().Debug("Dropped syslog messages by message filter",
+				zap.String("service", service),
+				zap.Int64("filtered", count),
+			)
+		}
+	}
+
+	queued, err := config.SyslogQueuedCounts()
+	if err != nil {
+		zap.L().Debug("Failed to read syslog-ng queue stats", zap.Error(err))
+		return
+	}
+	s.SyslogQueued = queued
+	for service, count := range queued {
+		if count > 0 {
+			zap.L().Info("Syslog messages buffered for delivery",
+				zap.String("service", service),
+				zap.Int64("queued", count),
+			)
+		}
+	}
+
+	buffers, err := config.SyslogDiskBufferUsage(cfg)
+	if err != nil {
+		zap.L().Debug("Failed to read syslog-ng disk buffer stats", zap.Error(err))
+		return
+	}
+	s.SyslogBuffers = buffers
+	for service, usage := range buffers {
+		if usage.CapacityBytes > 0 && float64(usage.UsedBytes) >= syslogBufferFullRatio*float64(usage.CapacityBytes) {
+			zap.L().Warn("Syslog disk buffer nearly full, messages will be dropped when it is",
+				zap.String("service", service),
+				zap.Int64("usedBytes", usage.UsedBytes),
+				zap.Int64("capacityBytes", usage.CapacityBytes),
+			)
+		}
+	}
 }
@@ -0,0 +1,46 @@
+package uptime
+
+import (
+	"sync"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/config"
+)
+
+// Status is a snapshot of the aggregator state, refreshed and logged on
+// every Wrapper tick. The controller has no endpoint for it yet, so the
+// logs, and Loki with LOG_TO_LOKI, are where it is reported.
+type Status struct {
+	UpdatedAt        time.Time                         `json:"updatedAt"`
+	SyslogRunning    bool                              `json:"syslogRunning"`
+	LogstashRunning  bool                              `json:"logstashRunning"`
+	LogstashHealthy  bool                              `json:"logstashHealthy"`
+	LogstashReasons  []string                          `json:"logstashReasons,omitempty"`
+	LogstashQueued   int64                             `json:"logstashQueued,omitempty"`
+	LogstashDLQBytes int64                             `json:"logstashDLQBytes,omitempty"`
+	LogstashSkipped  map[string]string                 `json:"logstashSkippedTargets,omitempty"`
+	SyslogRejected   map[string]int64                  `json:"syslogRejected,omitempty"`
+	SyslogFiltered   map[string]int64                  `json:"syslogFiltered,omitempty"`
+	SyslogQueued     map[string]int64                  `json:"syslogQueued,omitempty"`
+	SyslogBuffers    map[string]config.DiskBufferUsage `json:"syslogBuffers,omitempty"`
+	Images           map[string]config.ImageStatus     `json:"images,omitempty"`
+}
+
+var (
+	statusMu sync.RWMutex
+	status   Status
+)
+
+// CurrentStatus returns the last recorded aggregator status
+func CurrentStatus() Status {
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+	return status
+}
+
+func setStatus(s Status) {
+	s.UpdatedAt = time.Now()
+	statusMu.Lock()
+	status = s
+	statusMu.Unlock()
+}
@@ -10,26 +10,65 @@ import (
 var (
 	lastLogstashRestart time.Time
 	logstashBackoff     = time.Second * 30
+	lastPreflightRetry  time.Time
 )
 
+// how often Elasticsearch targets that failed the preflight are checked again
+const preflightRetryInterval = 5 * time.Minute
+
 func Wrapper(cfg *config.Config) {
-	syslogRunning, logstashRunning, logstashHealthy := MonitorServices(cfg.SyslogEnabled, cfg.LogstashEnabled)
+	syslogRunning, logstashRunning := MonitorServices(cfg.SyslogEnabled, cfg.LogstashEnabled && !cfg.NativePoller())
+
+	status := Status{
+		SyslogRunning: syslogRunning,
+		Images:        config.ImageStatuses(),
+	}
+
+	logstash := logstashHealth{healthy: true}
+	if cfg.LogstashEnabled {
+		if cfg.NativePoller() {
+			var reasons []string
+			logstashRunning, reasons = config.NativePollerStatus()
+			for _, reason := range reasons {
+				logstash.fail(true, "%s", reason)
+			}
+		} else if logstashRunning {
+			logstash = checkLogstashHealth(&status)
+		}
+		if !logstashRunning {
+			logstash.fail(true, "not running")
+		}
+		status.LogstashSkipped = config.ElasticsearchPreflightFailures()
+		for url, reason := range status.LogstashSkipped {
+			logstash.fail(false, "target %s skipped: %s", url, reason)
+		}
+		if !logstash.healthy {
+			zap.L().Warn("Logstash is unhealthy", zap.Strings("reasons", logstash.reasons))
+		}
+	}
+	logstashHealthy := logstash.healthy
+	status.LogstashRunning = logstashRunning
+	status.LogstashHealthy = logstashHealthy
+	status.LogstashReasons = logstash.reasons
 
 	// Only consider the services that are enabled in the config
 	allExpectedRunning := true
 
 	// Attempt to start Syslog if enabled and at least one service enabled but not running
-	if cfg.SyslogEnabled && config.AnyEnabled(cfg.SyslogServices) && !syslogRunning {
+	if cfg.SyslogEnabled && cfg.AnySyslogSource() && !syslogRunning {
 		zap.L().Warn("Syslog container not running, attempting to start...")
 		config.RestartSyslog(cfg)
 		allExpectedRunning = false // still consider it "not fully running" this tick
+	} else if syslogRunning {
+		collectSyslogStats(cfg, &status)
 	}
 
-	// Attempt to start Logstash if enabled but not running
-	if cfg.LogstashEnabled && (!logstashRunning || !logstashHealthy) {
+	// Attempt to (re)start Logstash if it is down, or unhealthy in a way a restart may fix
+	if cfg.LogstashEnabled && logstash.needsRestart {
 		if time.Since(lastLogstashRestart) >= logstashBackoff {
 			zap.L().Warn("Logstash is down or unhealthy, restarting...",
 				zap.Duration("backoff", logstashBackoff),
+				zap.Strings("reasons", logstash.reasons),
 			)
 			config.RestartLogstash(cfg)
 			lastLogstashRestart = time.Now()
@@ -45,6 +84,17 @@ func Wrapper(cfg *config.Config) {
 		}
 		allExpectedRunning = false // still consider it "not fully running" this tick
 
+	} else if cfg.LogstashEnabled && !logstashHealthy {
+		// a restart won't help, e.g. the collector is down or the config is invalid
+		allExpectedRunning = false
+
+		// skipped targets are added back once they pass the preflight
+		if len(status.LogstashSkipped) > 0 && time.Since(lastPreflightRetry) >= preflightRetryInterval {
+			zap.L().Info("Checking skipped Elasticsearch targets again")
+			lastPreflightRetry = time.Now()
+			config.ReloadLogstashPipeline(cfg)
+		}
+
 	} else if cfg.LogstashEnabled && logstashRunning && logstashHealthy {
 		// reset backoff if it recovers
 		if logstashBackoff != 30*time.Second {
@@ -53,6 +103,9 @@ func Wrapper(cfg *config.Config) {
 		}
 	}
 
+	setStatus(status)
+	zap.L().Info("Aggregator status", zap.Any("status", CurrentStatus()))
+
 	if allExpectedRunning {
 		// all services that should be running are indeed running and healthy
 		SendHeartbeat(cfg.AggregatorName, cfg.AuthSecret, cfg.HeartbeatIdentifier, cfg.HeartbeatUrl)
@@ -64,6 +117,7 @@ func Wrapper(cfg *config.Config) {
 			zap.Bool("logstashExpected", cfg.LogstashEnabled),
 			zap.Bool("logstashRunning", logstashRunning),
 			zap.Bool("logstashHealthy", logstashHealthy),
+			zap.Strings("logstashReasons", logstash.reasons),
 		)
 	}
 }
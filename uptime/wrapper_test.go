@@ -0,0 +1,96 @@
+package uptime
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/assets"
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/config"
+	"github.com/NxtGenIT/nxtfireguard-threat-feed-aggregator/docker"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const preflightOutput = "NFG_PREFLIGHT 200 0\n" + `[{"index":"logstash-2025.06.01"}]`
+
+func TestWrapperRestartsUnhealthyLogstash(t *testing.T) {
+	fake := docker.NewFake()
+	config.SetContainerRuntime(fake)
+	t.Cleanup(assets.Cleanup)
+
+	// the preflight check passes, the pipeline of the running logstash is gone
+	fake.WaitFunc = func(spec docker.ContainerSpec) (string, int) {
+		return preflightOutput, 0
+	}
+	fake.ExecFunc = func(container string, cmd []string) (string, int, error) {
+		if container == "nfg-logstash" && strings.Contains(strings.Join(cmd, " "), "_node/stats/pipelines") {
+			return `{"pipelines":{}}`, 0, nil
+		}
+		return "", 0, nil
+	}
+
+	backoff, lastRestart := logstashBackoff, lastLogstashRestart
+	t.Cleanup(func() { logstashBackoff, lastLogstashRestart = backoff, lastRestart })
+	lastLogstashRestart = time.Time{}
+
+	core, logs := observer.New(zap.InfoLevel)
+	t.Cleanup(zap.ReplaceGlobals(zap.New(core)))
+
+	cfg := &config.Config{
+		AggregatorName:        "tpot-01",
+		AuthSecret:            "secret",
+		NfgThreatCollectorUrl: "https://collector.example.com",
+		LogstashEnabled:       true,
+		LogstashIndexPattern:  "logstash-*",
+		LogstashSchedule:      "*/2 * * * * *",
+		LogstashPageSize:      1000,
+		LogstashTrackingField: "@timestamp",
+		LogstashNetwork:       assets.LogstashNetworkNone,
+		LogstashMode:          "container",
+		ElasticsearchTargets: []config.ElasticsearchTarget{
+			{URL: "https://es.example.com:9200", User: "elastic", Password: "changeme"},
+		},
+	}
+	config.HandleLogstashChange(cfg)
+	before, err := fake.ContainerInspect(context.Background(), "nfg-logstash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Wrapper(cfg)
+
+	after, err := fake.ContainerInspect(context.Background(), "nfg-logstash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.ID == before.ID || !after.State.Running {
+		t.Error("unhealthy logstash was not restarted")
+	}
+	if lastLogstashRestart.IsZero() || logstashBackoff != 2*backoff {
+		t.Errorf("restart was not recorded, backoff is %s", logstashBackoff)
+	}
+
+	// the status says why
+	s := CurrentStatus()
+	if s.LogstashHealthy || !s.LogstashRunning || len(s.LogstashReasons) == 0 || s.UpdatedAt.IsZero() {
+		t.Errorf("got status %+v", s)
+	}
+	if image := s.Images["nfg-logstash"]; image.State != "present" {
+		t.Errorf("got image status %+v", image)
+	}
+	if logs.FilterMessage("Aggregator status").Len() != 1 {
+		t.Error("status was not logged")
+	}
+
+	// within the backoff it is not restarted again
+	Wrapper(cfg)
+	again, err := fake.ContainerInspect(context.Background(), "nfg-logstash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != after.ID {
+		t.Error("logstash was restarted within the backoff")
+	}
+}